@@ -0,0 +1,261 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminDefaultLimit = 1000
+
+// adminRecord is an entry of the admin API listing, made of the index lines
+// of a record ID.
+type adminRecord struct {
+	ID      string
+	Date    time.Time
+	Request string
+	Path    string
+	Files   []string
+}
+
+// authorized tells whether a request holds the --admin-token-file token as
+// bearer token, answering 401 otherwise. Without token, requests are all
+// authorized.
+func (ghr goHRec) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if ghr.adminToken == "" {
+		return true
+	}
+	split := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(split) == 2 && strings.EqualFold(split[0], "Bearer") && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(split[1])), []byte(ghr.adminToken)) == 1 {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="gohrec"`)
+	http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+	return false
+}
+
+// pathOfRequestName returns the URL path of a request named by
+// makeRequestName.
+func pathOfRequestName(req string) string {
+	fields := strings.SplitN(req, " ", 3)
+	if len(fields) < 3 {
+		return ""
+	}
+	u, err := url.Parse(fields[2])
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// indexedRecords reads the records of the index, in their recording order.
+func (ghr goHRec) indexedRecords() ([]*adminRecord, error) {
+	ghr.indexMutex.Lock()
+	lines, err := readLines("index.log")
+	ghr.indexMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*adminRecord{}
+	byID := map[string]*adminRecord{}
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		record, ok := byID[fields[0]]
+		if !ok {
+			record = &adminRecord{ID: fields[0], Request: fields[2], Path: pathOfRequestName(fields[2])}
+			if info, err := os.Stat(fields[1]); err == nil {
+				record.Date = info.ModTime().UTC()
+			}
+			byID[record.ID] = record
+			records = append(records, record)
+		}
+		record.Files = append(record.Files, fields[1])
+	}
+	return records, nil
+}
+
+// indexedRecord returns the record of an ID from the index, with its
+// annotations file if any.
+func (ghr goHRec) indexedRecord(id string) (*adminRecord, error) {
+	records, err := ghr.indexedRecords()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.ID != id {
+			continue
+		}
+		if i := strings.Index(record.Files[0], "."+id+"."); i > -1 {
+			if file := record.Files[0][:i] + "." + id + ".annotations.json"; fileExists(file) {
+				record.Files = append(record.Files, file)
+			}
+		}
+		return record, nil
+	}
+	return nil, errRecordNotFound
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, value interface{}) {
+	content, err := json.MarshalIndent(value, "", " ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+	w.Write([]byte("\n"))
+}
+
+// adminRecordsHandler lists with GET the indexed records, optionally only the
+// ones whose path starts with `path`, written since `since` (a RFC 3339 date
+// or a duration like `1h`), up to `limit`, the most recent first with
+// `order=desc`.
+func (ghr goHRec) adminRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			since = time.Now().Add(-duration)
+		} else if since, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since `%s`, expected a RFC 3339 date or a duration.", value), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := adminDefaultLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit `%s`, expected a positive number.", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	order := query.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		http.Error(w, fmt.Sprintf("Invalid order `%s`, expected `asc` or `desc`.", order), http.StatusBadRequest)
+		return
+	}
+
+	records, err := ghr.indexedRecords()
+	if err != nil {
+		ghr.logError(errorStorage, "Error while reading index", err)
+		http.Error(w, "Error while reading index.", http.StatusInternalServerError)
+		return
+	}
+	if order == "desc" {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+	matched := []*adminRecord{}
+	for _, record := range records {
+		if len(matched) >= limit {
+			break
+		}
+		if !strings.HasPrefix(record.Path, query.Get("path")) || record.Date.Before(since) {
+			continue
+		}
+		matched = append(matched, record)
+	}
+	writeAdminJSON(w, matched)
+}
+
+// adminRecordHandler returns with GET the records of an ID keyed by their
+// kind (`request`, `response`, `pair`, `skip` or `annotations`), and removes
+// them with DELETE.
+func (ghr goHRec) adminRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == http.MethodDelete && ghr.worm {
+		http.Error(w, "Records cannot be deleted with --worm.", http.StatusForbidden)
+		return
+	}
+
+	record, err := ghr.indexedRecord(id)
+	if err == errRecordNotFound {
+		http.Error(w, "Record not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ghr.logError(errorStorage, "Error while reading index", err, "id", id)
+		http.Error(w, "Error while reading index.", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		removed := map[string]bool{}
+		for _, file := range record.Files {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				ghr.logError(errorStorage, "Error while removing record", err, "file", file)
+				continue
+			}
+			removed[filepath.Clean(file)] = true
+		}
+		removeEmptyDirs(".", removed)
+		ghr.trimIndex(removed)
+		ghr.log(slog.LevelInfo, "Deleted", "id", id, "removed", len(removed))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	kinds := map[string]json.RawMessage{}
+	for _, file := range record.Files {
+		content, err := readRecordFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			ghr.logError(errorStorage, "Error while reading record", err, "file", file)
+			http.Error(w, "Error while reading record.", http.StatusInternalServerError)
+			return
+		}
+		for _, kind := range []string{"request", "response", "pair", "skip", "annotations"} {
+			if isRecordFile(file, kind) && json.Valid(content) {
+				kinds[kind] = content
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		http.Error(w, "Record not found.", http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, kinds)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// amplifyVariation replaces the matches of pattern in the copies of a request
+// by template, where `{{n}}` is the number of the copy, `{{uuid}}` a UUID
+// generated for the copy, and `$1` a group of the match.
+type amplifyVariation struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+// amplifier multiplies the requests to redo to synthesize a higher load.
+type amplifier struct {
+	copies     int
+	variations []amplifyVariation
+}
+
+// makeAmplifier returns the amplifier making copies of each request, with
+// variations like `user-[0-9]+=user-{{n}}`, nil if there is nothing to do.
+func makeAmplifier(copies int, variations []string) (*amplifier, error) {
+	if copies < 1 {
+		return nil, fmt.Errorf("Invalid --amplify `%d`, expected a positive number of copies.", copies)
+	}
+	if copies == 1 && len(variations) == 0 {
+		return nil, nil
+	}
+	a := &amplifier{copies: copies}
+	for _, variation := range variations {
+		split := strings.SplitN(variation, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("Invalid --amplify-vary `%s`, expected `<regexp>=<template>`.", variation)
+		}
+		pattern, err := regexp.Compile(split[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --amplify-vary `%s`: %s", variation, err)
+		}
+		a.variations = append(a.variations, amplifyVariation{pattern: pattern, template: split[1]})
+	}
+	return a, nil
+}
+
+// vary applies the variations to the URI, header values and text body of a
+// copy of a request.
+func (a *amplifier) vary(record redoRecord, n int) redoRecord {
+	if len(a.variations) == 0 {
+		return record
+	}
+	replacer := strings.NewReplacer("{{n}}", strconv.Itoa(n), "{{uuid}}", makeUUID())
+	apply := func(value string) string {
+		for _, variation := range a.variations {
+			value = variation.pattern.ReplaceAllString(value, replacer.Replace(variation.template))
+		}
+		return value
+	}
+
+	record.URI = apply(record.URI)
+	headers := make([]string, len(record.Headers))
+	for i, header := range record.Headers {
+		if split := strings.SplitN(header, ": ", 2); len(split) == 2 {
+			header = split[0] + ": " + apply(split[1])
+		}
+		headers[i] = header
+	}
+	record.Headers = headers
+	if utf8.ValidString(record.Body) {
+		record.Body = apply(record.Body)
+	}
+	return record
+}
+
+// apply returns the copies of each request, in the order of the requests.
+func (a *amplifier) apply(files []redoFile) []redoFile {
+	if a == nil {
+		return files
+	}
+	amplified := make([]redoFile, 0, len(files)*a.copies)
+	for _, file := range files {
+		for n := 1; n <= a.copies; n++ {
+			amplified = append(amplified, redoFile{name: fmt.Sprintf("%s#%d", file.name, n), record: a.vary(file.record, n)})
+		}
+	}
+	return amplified
+}
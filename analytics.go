@@ -0,0 +1,156 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"expvar"
+	"mime"
+	"sort"
+	"sync"
+)
+
+const (
+	analyticsMaxEndpoints = 1000
+	analyticsMaxFields    = 200
+	analyticsMaxDepth     = 5
+	analyticsOther        = "(other)"
+)
+
+// payloadStats aggregates the bodies of one direction of an endpoint.
+type payloadStats struct {
+	Count     int64            `json:"count"`
+	Bytes     int64            `json:"bytes"`
+	AvgBytes  int64            `json:"avg_bytes"`
+	JSONCount int64            `json:"json_count"`
+	Fields    map[string]int64 `json:"fields,omitempty"`
+}
+
+type endpointStats struct {
+	Request  payloadStats `json:"request"`
+	Response payloadStats `json:"response"`
+}
+
+// payloadAnalytics aggregates characteristics of recorded payloads, but never
+// their values: content types, body sizes and JSON field frequencies of
+// endpoints, their identifier segments being templated.
+type payloadAnalytics struct {
+	mutex        sync.Mutex
+	ContentTypes map[string]int64
+	Endpoints    map[string]*endpointStats
+}
+
+// makePayloadAnalytics publishes the analytics as `gohrec_payloads` metrics.
+func makePayloadAnalytics(enabled bool) *payloadAnalytics {
+	if !enabled {
+		return nil
+	}
+	pa := &payloadAnalytics{ContentTypes: map[string]int64{}, Endpoints: map[string]*endpointStats{}}
+	expvar.Publish("gohrec_payloads", expvar.Func(pa.snapshot))
+	return pa
+}
+
+// jsonFields adds the paths of the fields of a JSON document, array items
+// being suffixed by `[]`.
+func jsonFields(doc interface{}, prefix string, depth int, fields map[string]bool) {
+	if depth > analyticsMaxDepth {
+		return
+	}
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		for name, value := range doc {
+			field := name
+			if prefix != "" {
+				field = prefix + "." + name
+			}
+			fields[field] = true
+			jsonFields(value, field, depth+1, fields)
+		}
+	case []interface{}:
+		for _, item := range doc {
+			jsonFields(item, prefix+"[]", depth+1, fields)
+		}
+	}
+}
+
+// observe accounts for a body of kind `request` or `response` of an endpoint.
+func (pa *payloadAnalytics) observe(kind, method, path, contentType string, body []byte) {
+	if pa == nil {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		mediaType = "none"
+	}
+	fields := map[string]bool{}
+	doc, isJSON := decodeJSON(string(body))
+	if isJSON {
+		jsonFields(doc, "", 0, fields)
+	}
+	template, _ := templatePath(path)
+	endpoint := method + " " + template
+
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	pa.ContentTypes[kind+" "+mediaType]++
+	if pa.Endpoints[endpoint] == nil && len(pa.Endpoints) >= analyticsMaxEndpoints {
+		endpoint = analyticsOther
+	}
+	if pa.Endpoints[endpoint] == nil {
+		pa.Endpoints[endpoint] = &endpointStats{}
+	}
+	stats := &pa.Endpoints[endpoint].Request
+	if kind == "response" {
+		stats = &pa.Endpoints[endpoint].Response
+	}
+	stats.Count++
+	stats.Bytes += int64(len(body))
+	stats.AvgBytes = stats.Bytes / stats.Count
+	if !isJSON {
+		return
+	}
+	stats.JSONCount++
+	if stats.Fields == nil {
+		stats.Fields = map[string]int64{}
+	}
+	names := []string{}
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		if _, ok := stats.Fields[field]; !ok && len(stats.Fields) >= analyticsMaxFields {
+			field = analyticsOther
+		}
+		stats.Fields[field]++
+	}
+}
+
+// snapshot returns a copy of the analytics, safe to serialize.
+func (pa *payloadAnalytics) snapshot() interface{} {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	snapshot := map[string]interface{}{}
+	contentTypes := map[string]int64{}
+	for mediaType, count := range pa.ContentTypes {
+		contentTypes[mediaType] = count
+	}
+	endpoints := map[string]endpointStats{}
+	for endpoint, stats := range pa.Endpoints {
+		copied := *stats
+		for _, payload := range []*payloadStats{&copied.Request, &copied.Response} {
+			if payload.Fields != nil {
+				fields := map[string]int64{}
+				for field, count := range payload.Fields {
+					fields[field] = count
+				}
+				payload.Fields = fields
+			}
+		}
+		endpoints[endpoint] = copied
+	}
+	snapshot["content_types"] = contentTypes
+	snapshot["endpoints"] = endpoints
+	return snapshot
+}
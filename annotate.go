@@ -0,0 +1,207 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var annotationsMutex sync.Mutex
+
+var errRecordNotFound = errors.New("record not found")
+
+// annotation is a free-form note attached to a record, stored alongside it in
+// a `.annotations.json` file.
+type annotation struct {
+	Date   time.Time
+	Note   string   `json:",omitempty"`
+	Labels []string `json:",omitempty"`
+}
+
+// findRecordFiles returns the files of the records of an ID.
+func findRecordFiles(dir, id string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && recordIDOfFile(path) == id && strings.Contains(filepath.Base(path), "."+id+".") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// annotationsFile returns the annotations file of the records of an ID,
+// given their files.
+func annotationsFile(files []string, id string) (string, error) {
+	for _, file := range files {
+		if recordIDOfFile(file) != id {
+			continue
+		}
+		if i := strings.LastIndex(file, "."+id+"."); i > -1 {
+			return file[:i] + "." + id + ".annotations.json", nil
+		}
+	}
+	return "", errRecordNotFound
+}
+
+func loadAnnotations(file string) ([]annotation, error) {
+	annotations := []annotation{}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return annotations, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &annotations)
+	return annotations, err
+}
+
+// annotateRecord appends an annotation to an annotations file.
+func annotateRecord(file string, a annotation) ([]annotation, error) {
+	annotationsMutex.Lock()
+	defer annotationsMutex.Unlock()
+
+	annotations, err := loadAnnotations(file)
+	if err != nil {
+		return nil, err
+	}
+	if a.Date.IsZero() {
+		a.Date = time.Now().UTC()
+	}
+	annotations = append(annotations, a)
+
+	content, err := json.MarshalIndent(annotations, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	return annotations, ioutil.WriteFile(file, content, 0644)
+}
+
+// annotationsHandler lists with GET and adds with POST the annotations of a
+// record, the body of a POST being either a JSON annotation or a plain note.
+// The record is looked up in the index, with the token of --admin-token-file.
+func (ghr goHRec) annotationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	if !safeRecordIDPattern.MatchString(id) {
+		http.Error(w, "Invalid record ID.", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var annotations []annotation
+	var file string
+	record, err := ghr.indexedRecord(id)
+	if err == nil {
+		file, err = annotationsFile(record.Files, id)
+	}
+
+	switch {
+	case err != nil:
+	case r.Method == http.MethodGet:
+		annotationsMutex.Lock()
+		annotations, err = loadAnnotations(file)
+		annotationsMutex.Unlock()
+	default:
+		body, readErr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if readErr != nil {
+			http.Error(w, readErr.Error(), http.StatusBadRequest)
+			return
+		}
+		var a annotation
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.Unmarshal(body, &a); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid annotation: %s", err), http.StatusBadRequest)
+				return
+			}
+		} else {
+			a.Note = strings.TrimSpace(string(body))
+		}
+		if a.Note == "" && len(a.Labels) == 0 {
+			http.Error(w, "Empty annotation.", http.StatusBadRequest)
+			return
+		}
+		annotations, err = annotateRecord(file, a)
+		if err == nil {
+			ghr.log(slog.LevelInfo, "Annotated", "id", id)
+		}
+	}
+
+	if err == errRecordNotFound {
+		http.Error(w, "Record not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ghr.logError(errorStorage, "Error while annotating", err, "id", id)
+		http.Error(w, "Error while annotating.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	encoder.Encode(annotations)
+}
+
+func annotate() {
+	annotator := flag.NewFlagSet("annotate", flag.PanicOnError)
+	dir := annotator.String("dir", ".", "Directory of the records.")
+	id := annotator.String("id", "", "ID of the record to annotate.")
+	note := annotator.String("note", "", "Free-form note to attach to the record.")
+	var labels arrayStringFlag
+	annotator.Var(&labels, "label", "Label to attach to the record, can be repeated.")
+	annotator.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  id: %s", *id)
+	log.Printf("  note: %s", *note)
+	log.Printf("  label: %s", labels.String())
+
+	if *id == "" {
+		log.Fatal("--id is required.")
+	}
+	files, err := findRecordFiles(*dir, *id)
+	if err != nil {
+		log.Fatalf("Error while finding record: %s", err)
+	}
+	annotations, err := annotationsFile(files, *id)
+	if err != nil {
+		log.Fatalf("Error while finding record: %s", err)
+	}
+	if *note == "" && len(labels) == 0 {
+		list, err := loadAnnotations(annotations)
+		if err != nil {
+			log.Fatalf("Error while loading annotations: %s", err)
+		}
+		for _, a := range list {
+			fmt.Printf("%s\t%s\t%s\n", a.Date.Format(time.RFC3339), strings.Join(a.Labels, ","), a.Note)
+		}
+		return
+	}
+
+	if _, err := annotateRecord(annotations, annotation{Note: *note, Labels: labels}); err != nil {
+		log.Fatalf("Error while annotating record: %s", err)
+	}
+	log.Printf("Annotated %s.", *id)
+}
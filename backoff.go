@@ -0,0 +1,121 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redoRetrier retries the redone requests failing with one of the outcomes
+// to retry, waiting for backoff, doubled after each attempt.
+type redoRetrier struct {
+	retries  int
+	backoff  time.Duration
+	statuses map[string]bool
+	connect  bool
+	timeout  bool
+}
+
+// makeRedoRetrier returns the retrier of a comma-separated list of outcomes
+// to retry on, like `5xx,429,connect-error,timeout`, nil without retries.
+func makeRedoRetrier(retries int, backoff time.Duration, retryOn string) (*redoRetrier, error) {
+	if retries < 0 {
+		return nil, fmt.Errorf("Invalid --retries `%d`, expected a positive number.", retries)
+	}
+	if retries == 0 {
+		return nil, nil
+	}
+	rr := &redoRetrier{retries: retries, backoff: backoff, statuses: map[string]bool{}}
+	for _, outcome := range strings.Split(retryOn, ",") {
+		switch outcome = strings.ToLower(strings.TrimSpace(outcome)); {
+		case outcome == "":
+		case outcome == "connect-error":
+			rr.connect = true
+		case outcome == "timeout":
+			rr.timeout = true
+		case len(outcome) == 3 && strings.HasSuffix(outcome, "xx") && outcome[0] >= '1' && outcome[0] <= '5':
+			rr.statuses[outcome] = true
+		default:
+			if code, err := strconv.Atoi(outcome); err != nil || code < 100 || code > 599 {
+				return nil, fmt.Errorf("Unknown --retry-on `%s`, expected status codes (like `503`), classes (like `5xx`), `connect-error` or `timeout`.", outcome)
+			}
+			rr.statuses[outcome] = true
+		}
+	}
+	return rr, nil
+}
+
+// retriable tells if the outcome of an attempt is to retry, and describes it.
+func (rr *redoRetrier) retriable(resp *http.Response, err error) (bool, string) {
+	if err != nil {
+		var opErr *net.OpError
+		var netErr net.Error
+		switch {
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			return rr.connect, "connect-error: " + err.Error()
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return rr.timeout, "timeout: " + err.Error()
+		}
+		return false, err.Error()
+	}
+	code := strconv.Itoa(resp.StatusCode)
+	return rr.statuses[code] || rr.statuses[code[:1]+"xx"], resp.Status
+}
+
+// wrap returns a transport retrying the requests sent with next.
+func (rr *redoRetrier) wrap(next http.RoundTripper) http.RoundTripper {
+	if rr == nil {
+		return next
+	}
+	return retryingTransport{retrier: rr, next: next}
+}
+
+type retryingTransport struct {
+	retrier *redoRetrier
+	next    http.RoundTripper
+}
+
+func (rt retryingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := rt.retrier.backoff
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+		resp, err := rt.next.RoundTrip(attemptReq)
+		retry, outcome := rt.retrier.retriable(resp, err)
+		if !retry || attempt > rt.retrier.retries || (req.Body != nil && req.GetBody == nil) {
+			if attempt > 1 {
+				log.Printf("Attempt %d/%d: %s %s: %s", attempt, rt.retrier.retries+1, req.Method, req.URL, outcome)
+			}
+			return resp, err
+		}
+		log.Printf("Attempt %d/%d: %s %s: %s, retrying in %s", attempt, rt.retrier.retries+1, req.Method, req.URL, outcome, backoff)
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,452 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errBackupNotFound = errors.New("not found")
+
+// backupTarget stores the objects of backups under keys.
+type backupTarget interface {
+	get(key string) ([]byte, error)
+	put(key string, content []byte) error
+}
+
+// localTarget stores backups in a directory, like a mounted volume.
+type localTarget struct {
+	dir string
+}
+
+func (lt localTarget) get(key string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(lt.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, errBackupNotFound
+	}
+	return content, err
+}
+
+func (lt localTarget) put(key string, content []byte) error {
+	file := filepath.Join(lt.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0644)
+}
+
+// s3Target stores backups in an S3 bucket, or in a bucket of an S3-compatible
+// service when endpoint is set, signing requests with AWS Signature Version 4.
+type s3Target struct {
+	bucket, prefix, region, endpoint string
+	accessKey, secretKey, token      string
+	client                           http.Client
+}
+
+func makeS3Target(to, region, endpoint string) (*s3Target, error) {
+	u, err := url.Parse(to)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid --to `%s`, expected `s3://bucket[/prefix]`.", to)
+	}
+	st := &s3Target{
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    region,
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    http.Client{Timeout: 5 * time.Minute},
+	}
+	if st.accessKey == "" || st.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required to back up to S3.")
+	}
+	return st, nil
+}
+
+// objectURL uses virtual-hosted-style URLs on AWS, and path-style ones on
+// other endpoints as most S3-compatible services expect.
+func (st *s3Target) objectURL(key string) string {
+	if st.prefix != "" {
+		key = st.prefix + "/" + key
+	}
+	escaped := []string{}
+	for _, segment := range strings.Split(key, "/") {
+		escaped = append(escaped, s3Escape(segment))
+	}
+	if st.endpoint != "" {
+		return st.endpoint + "/" + s3Escape(st.bucket) + "/" + strings.Join(escaped, "/")
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", st.bucket, st.region, strings.Join(escaped, "/"))
+}
+
+// s3Escape encodes a path segment as expected by Signature Version 4.
+func s3Escape(segment string) string {
+	var sb strings.Builder
+	for _, b := range []byte(segment) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func (st *s3Target) do(method, key string, content []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, st.objectURL(key), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	st.sign(req, content, time.Now().UTC())
+
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errBackupNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (st *s3Target) sign(req *http.Request, content []byte, now time.Time) {
+	hash := sha256.Sum256(content)
+	payloadHash := hex.EncodeToString(hash[:])
+	date := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", date)
+	if st.token != "" {
+		req.Header.Set("X-Amz-Security-Token", st.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(values[0])
+		}
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), "", canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := now.Format("20060102") + "/" + st.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + st.secretKey)
+	for _, part := range []string{now.Format("20060102"), st.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", st.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (st *s3Target) get(key string) ([]byte, error) {
+	return st.do(http.MethodGet, key, nil)
+}
+
+func (st *s3Target) put(key string, content []byte) error {
+	_, err := st.do(http.MethodPut, key, content)
+	return err
+}
+
+type backupEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	SHA256  string
+}
+
+// backupManifest lists all the records of a backup, with the hash of their
+// decompressed content naming the object holding it, so that a record is
+// uploaded once whatever its compression and however many backups contain it.
+type backupManifest struct {
+	Date        time.Time
+	Incremental bool
+	Encryption  string `json:",omitempty"`
+	Uploaded    int
+	Files       []backupEntry
+}
+
+// backuper uploads the records of a directory to a backup target.
+type backuper struct {
+	dir         string
+	target      backupTarget
+	cipher      cipher.AEAD
+	concurrency int
+	mutex       sync.Mutex
+	objects     map[string]bool
+}
+
+func makeBackupCipher(keyFile string) (cipher.AEAD, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading --encrypt-key-file: %s", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("Invalid --encrypt-key-file, expected 64 hexadecimal characters.")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func objectKey(hash string) string {
+	return "objects/" + hash[:2] + "/" + hash
+}
+
+// pack gzips the content and, with encryption, seals it with AES-256-GCM,
+// prepending the random nonce.
+func (b *backuper) pack(content []byte) ([]byte, error) {
+	packed, err := compressRecord("gzip", content)
+	if err != nil || b.cipher == nil {
+		return packed, err
+	}
+	nonce := make([]byte, b.cipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.cipher.Seal(nonce, nonce, packed, nil), nil
+}
+
+// backupFile uploads a record unless an object already holds its content.
+func (b *backuper) backupFile(entry *backupEntry) (bool, error) {
+	content, err := readRecordFile(filepath.Join(b.dir, entry.Name))
+	if err != nil {
+		return false, err
+	}
+	hash := sha256.Sum256(content)
+	entry.SHA256 = hex.EncodeToString(hash[:])
+
+	b.mutex.Lock()
+	if b.objects[entry.SHA256] {
+		b.mutex.Unlock()
+		return false, nil
+	}
+	b.objects[entry.SHA256] = true
+	b.mutex.Unlock()
+
+	packed, err := b.pack(content)
+	if err == nil {
+		err = b.target.put(objectKey(entry.SHA256), packed)
+	}
+	if err != nil {
+		b.mutex.Lock()
+		delete(b.objects, entry.SHA256)
+		b.mutex.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// run backs up the records, reusing the entries of the previous manifest
+// for unchanged files when set.
+func (b *backuper) run(previous *backupManifest, m *backupManifest) error {
+	known := map[string]backupEntry{}
+	b.objects = map[string]bool{}
+	if previous != nil {
+		for _, entry := range previous.Files {
+			known[entry.Name] = entry
+			b.objects[entry.SHA256] = true
+		}
+	}
+
+	files, err := listRecordFiles(b.dir)
+	if err != nil {
+		return err
+	}
+	pending := []int{}
+	for _, file := range files {
+		name, err := filepath.Rel(b.dir, file.path)
+		if err != nil {
+			return err
+		}
+		entry := backupEntry{Name: filepath.ToSlash(name), Size: file.size, ModTime: file.modTime.UTC()}
+		if old, ok := known[entry.Name]; ok && old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) {
+			entry.SHA256 = old.SHA256
+		} else {
+			pending = append(pending, len(m.Files))
+		}
+		m.Files = append(m.Files, entry)
+	}
+	log.Printf("%d record(s) found, %d new or changed.", len(m.Files), len(pending))
+
+	var wg sync.WaitGroup
+	var errs []string
+	indexes := make(chan int)
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				entry := &m.Files[index]
+				uploaded, err := b.backupFile(entry)
+				b.mutex.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %s", entry.Name, err))
+				} else if uploaded {
+					m.Uploaded++
+				}
+				b.mutex.Unlock()
+			}
+		}()
+	}
+	for _, index := range pending {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Error while uploading %d record(s):\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func loadBackupManifest(target backupTarget, encryption string) (*backupManifest, error) {
+	content, err := target.get("manifests/latest.json")
+	if err == errBackupNotFound {
+		log.Print("No previous backup found, backing up all records.")
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error while reading previous manifest: %s", err)
+	}
+	var previous backupManifest
+	if err := json.Unmarshal(content, &previous); err != nil {
+		return nil, fmt.Errorf("Error while parsing previous manifest: %s", err)
+	}
+	if previous.Encryption != encryption {
+		log.Print("Previous backup was encrypted differently, backing up all records.")
+		return nil, nil
+	}
+	log.Printf("Previous backup of %s found with %d record(s).", previous.Date.Format(time.RFC3339), len(previous.Files))
+	return &previous, nil
+}
+
+func backup() {
+	backupFlags := flag.NewFlagSet("backup", flag.PanicOnError)
+	dir := backupFlags.String("dir", ".", "Directory of the records to back up.")
+	to := backupFlags.String("to", "", "Destination of the backup: `s3://bucket[/prefix]` or a directory.")
+	incremental := backupFlags.Bool("incremental", false, "Only upload the records that are new or changed since the last backup.")
+	encryptKeyFile := backupFlags.String("encrypt-key-file", "", "If set, file holding the 64 hexadecimal characters of the AES-256 key records are encrypted with before upload.")
+	region := backupFlags.String("region", "", "AWS region of the bucket, defaults to AWS_REGION or `us-east-1`.")
+	endpoint := backupFlags.String("endpoint", "", "If set, URL (like `http://minio:9000`) of an S3-compatible service to use instead of AWS.")
+	concurrency := backupFlags.Int("concurrency", 8, "Number of records uploaded concurrently.")
+	backupFlags.Parse(os.Args[2:])
+
+	if *region == "" {
+		if *region = os.Getenv("AWS_REGION"); *region == "" {
+			*region = "us-east-1"
+		}
+	}
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  to: %s", *to)
+	log.Printf("  incremental: %t", *incremental)
+	log.Printf("  encrypt-key-file: %s", *encryptKeyFile)
+	log.Printf("  region: %s", *region)
+	log.Printf("  endpoint: %s", *endpoint)
+	log.Printf("  concurrency: %d", *concurrency)
+
+	if *concurrency < 1 {
+		log.Fatal("--concurrency must be at least 1.")
+	}
+
+	var target backupTarget
+	switch {
+	case *to == "":
+		log.Fatal("--to is required.")
+	case strings.HasPrefix(*to, "s3://"):
+		st, err := makeS3Target(*to, *region, *endpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		target = st
+	default:
+		target = localTarget{dir: *to}
+	}
+
+	aead, err := makeBackupCipher(*encryptKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m := &backupManifest{Date: time.Now().UTC(), Incremental: *incremental, Files: []backupEntry{}}
+	if aead != nil {
+		m.Encryption = "aes-256-gcm"
+	}
+
+	var previous *backupManifest
+	if *incremental {
+		if previous, err = loadBackupManifest(target, m.Encryption); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	b := &backuper{dir: *dir, target: target, cipher: aead, concurrency: *concurrency}
+	if err := b.run(previous, m); err != nil {
+		log.Fatal(err)
+	}
+
+	content, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		log.Fatalf("Error while serializing manifest: %s", err)
+	}
+	name := "manifests/" + m.Date.Format("2006-01-02T15-04-05Z") + ".json"
+	for _, key := range []string{name, "manifests/latest.json"} {
+		if err := target.put(key, content); err != nil {
+			log.Fatalf("Error while uploading manifest: %s", err)
+		}
+	}
+	log.Printf("Backed up %d record(s) to %s, %d object(s) uploaded, manifest %s.", len(m.Files), *to, m.Uploaded, name)
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type benchResults struct {
+	Requests, Concurrency, BodySize int
+	Errors                          int
+	Duration                        time.Duration
+	Throughput                      float64
+	LatencyP50, LatencyP95          time.Duration
+	LatencyP99                      time.Duration
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+// runBench records requests with a recorder running in-process in a temporary
+// directory, measuring its throughput and latencies.
+func runBench(requests, concurrency, bodySize int) (benchResults, error) {
+	results := benchResults{Requests: requests, Concurrency: concurrency, BodySize: bodySize}
+
+	dir, err := ioutil.TempDir("", "gohrec-bench")
+	if err != nil {
+		return results, err
+	}
+	defer os.RemoveAll(dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		return results, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return results, err
+	}
+	defer os.Chdir(cwd)
+
+	gohrec := goHRec{
+		dateFormat:    defaultDateFormat,
+		maxBodySize:   -1,
+		respondStatus: http.StatusCreated,
+	}
+	server := httptest.NewServer(http.HandlerFunc(gohrec.handler))
+	defer server.Close()
+
+	body := bytes.Repeat([]byte("a"), bodySize)
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency}}
+	latencies := make([]time.Duration, requests)
+	jobs := make(chan int)
+	var errors int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				begin := time.Now()
+				resp, err := client.Post(server.URL+"/bench", "text/plain", bytes.NewReader(body))
+				if err == nil {
+					ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+				latencies[i] = time.Since(begin)
+				if err != nil || resp.StatusCode != http.StatusCreated {
+					mutex.Lock()
+					errors++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	results.Duration = time.Since(start)
+	results.Errors = errors
+	results.Throughput = float64(requests) / results.Duration.Seconds()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	results.LatencyP50 = percentile(latencies, 0.50)
+	results.LatencyP95 = percentile(latencies, 0.95)
+	results.LatencyP99 = percentile(latencies, 0.99)
+	return results, nil
+}
+
+// compareBench returns the regressions of the current results exceeding the
+// threshold, in percent, compared to the baseline.
+func compareBench(baseline, current benchResults, threshold float64) []string {
+	regressions := []string{}
+	if baseline.Throughput > 0 {
+		if loss := (baseline.Throughput - current.Throughput) / baseline.Throughput * 100; loss > threshold {
+			regressions = append(regressions, "throughput")
+			log.Printf("Regression: throughput %.1f req/s vs %.1f req/s (-%.1f%%)", current.Throughput, baseline.Throughput, loss)
+		}
+	}
+	latencies := []struct {
+		name              string
+		baseline, current time.Duration
+	}{
+		{"p50", baseline.LatencyP50, current.LatencyP50},
+		{"p95", baseline.LatencyP95, current.LatencyP95},
+		{"p99", baseline.LatencyP99, current.LatencyP99},
+	}
+	for _, latency := range latencies {
+		if latency.baseline <= 0 {
+			continue
+		}
+		if gain := float64(latency.current-latency.baseline) / float64(latency.baseline) * 100; gain > threshold {
+			regressions = append(regressions, latency.name)
+			log.Printf("Regression: latency %s %s vs %s (+%.1f%%)", latency.name, latency.current, latency.baseline, gain)
+		}
+	}
+	return regressions
+}
+
+func bench() {
+	bencher := flag.NewFlagSet("bench", flag.PanicOnError)
+	requests := bencher.Int("requests", 10000, "Number of requests to record.")
+	concurrency := bencher.Int("concurrency", 16, "Number of concurrent clients.")
+	bodySize := bencher.Int("body-size", 1024, "Size in bytes of the body of each request.")
+	baseline := bencher.String("baseline", "", "If set, JSON results file to compare against, exiting with an error on regression.")
+	threshold := bencher.Float64("threshold", 10, "Maximum regression in percent tolerated against the baseline.")
+	save := bencher.String("save", "", "If set, file where the JSON results are written, to be used later as baseline.")
+	bencher.Parse(os.Args[2:])
+
+	log.Printf("  requests: %d", *requests)
+	log.Printf("  concurrency: %d", *concurrency)
+	log.Printf("  body-size: %d", *bodySize)
+	log.Printf("  baseline: %s", *baseline)
+	log.Printf("  threshold: %.1f", *threshold)
+	log.Printf("  save: %s", *save)
+
+	results, err := runBench(*requests, *concurrency, *bodySize)
+	if err != nil {
+		log.Fatalf("Error while benchmarking: %s", err)
+	}
+	log.Printf("Results: %.1f req/s, p50 %s, p95 %s, p99 %s, %d error(s)", results.Throughput, results.LatencyP50, results.LatencyP95, results.LatencyP99, results.Errors)
+
+	if *save != "" {
+		content, err := json.MarshalIndent(results, "", " ")
+		if err != nil {
+			log.Fatalf("Error while serializing results: %s", err)
+		}
+		if err := ioutil.WriteFile(*save, content, 0644); err != nil {
+			log.Fatalf("Error while saving results: %s", err)
+		}
+	}
+
+	if *baseline != "" {
+		content, err := ioutil.ReadFile(*baseline)
+		if err != nil {
+			log.Fatalf("Error while reading baseline: %s", err)
+		}
+		var base benchResults
+		if err := json.Unmarshal(content, &base); err != nil {
+			log.Fatalf("Error while unmarshalling baseline: %s", err)
+		}
+		if regressions := compareBench(base, results, *threshold); len(regressions) > 0 {
+			log.Fatalf("Failed: %d regression(s) above %.1f%%.", len(regressions), *threshold)
+		}
+		log.Printf("Passed: no regression above %.1f%%.", *threshold)
+	}
+}
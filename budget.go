@@ -0,0 +1,118 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// bodyTruncation describes a body cut by its budget, the hash being the one
+// of the full body.
+type bodyTruncation struct {
+	Size       int64
+	Head, Tail int64
+	SHA256     string
+}
+
+// bodyBudgetFlag is a `<path regexp>=<size>` budget keeping the first and
+// last size bytes of larger bodies of the matching endpoints.
+type bodyBudgetFlag struct {
+	regex *regexp.Regexp
+	size  int64
+	raw   string
+}
+
+func (bbf *bodyBudgetFlag) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return fmt.Errorf("Invalid body budget `%s`, expected `<path regexp>=<size>`.", value)
+	}
+	regex, err := regexp.Compile(value[:i])
+	if err != nil {
+		return err
+	}
+	size, err := parseSize(value[i+1:])
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return fmt.Errorf("Invalid body budget `%s`, expected a positive size.", value)
+	}
+	bbf.regex, bbf.size, bbf.raw = regex, size, value
+	return nil
+}
+
+func (bbf *bodyBudgetFlag) String() string {
+	return bbf.raw
+}
+
+type arrayBodyBudgetFlag []bodyBudgetFlag
+
+func (abbf *arrayBodyBudgetFlag) Set(value string) error {
+	item := bodyBudgetFlag{}
+	if err := item.Set(value); err != nil {
+		return err
+	}
+	*abbf = append(*abbf, item)
+	return nil
+}
+
+func (abbf *arrayBodyBudgetFlag) String() string {
+	if abbf == nil {
+		return "[]"
+	}
+	out := []string{}
+	for _, item := range *abbf {
+		out = append(out, "`"+item.String()+"`")
+	}
+	return "[ " + strings.Join(out, ", ") + " ]"
+}
+
+// budgetOf returns the head and tail size kept for the bodies of a path, 0
+// when they are not budgeted.
+func (abbf arrayBodyBudgetFlag) budgetOf(path string) int64 {
+	for _, item := range abbf {
+		if item.regex.MatchString(path) {
+			return item.size
+		}
+	}
+	return 0
+}
+
+// truncateBody keeps the head and the tail of a body exceeding the budget of
+// its endpoint, with a marker of the gap in between.
+func (ghr goHRec) truncateBody(record *baseInfo, path string, content []byte) []byte {
+	budget := ghr.bodyBudgets.budgetOf(path)
+	if budget == 0 || int64(len(content)) <= 2*budget {
+		return content
+	}
+	head, tail := budget, int64(len(content))-budget
+	// Text bodies are cut on rune boundaries to be kept as text.
+	if utf8.Valid(content) {
+		for head > 0 && !utf8.RuneStart(content[head]) {
+			head--
+		}
+		for tail < int64(len(content)) && !utf8.RuneStart(content[tail]) {
+			tail++
+		}
+	}
+	hash := sha256.Sum256(content)
+	record.BodyTruncated = &bodyTruncation{
+		Size:   int64(len(content)),
+		Head:   head,
+		Tail:   int64(len(content)) - tail,
+		SHA256: hex.EncodeToString(hash[:]),
+	}
+	marker := fmt.Sprintf("\n[... gohrec: %d bytes truncated ...]\n", tail-head)
+	truncated := make([]byte, 0, head+int64(len(content))-tail+int64(len(marker)))
+	truncated = append(truncated, content[:head]...)
+	truncated = append(truncated, marker...)
+	return append(truncated, content[tail:]...)
+}
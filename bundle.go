@@ -0,0 +1,195 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exchange is a request, its response and their annotations.
+type exchange struct {
+	ID                string
+	Request, Response *exportRecord
+	Annotations       []annotation
+	Duration          time.Duration
+	files             []string
+}
+
+// loadExchange loads the records of an ID found in a directory.
+func loadExchange(dir, id string) (exchange, error) {
+	ex := exchange{ID: id}
+	files, err := findRecordFiles(dir, id)
+	if err != nil {
+		return ex, err
+	}
+	if len(files) == 0 {
+		return ex, errRecordNotFound
+	}
+	ex.files = files
+
+	for _, file := range files {
+		if isRecordFile(file, "annotations") {
+			if ex.Annotations, err = loadAnnotations(file); err != nil {
+				return ex, err
+			}
+			continue
+		}
+		for _, kind := range []string{"request", "response"} {
+			pair := isRecordFile(file, "pair")
+			if !isRecordFile(file, kind) && !pair {
+				continue
+			}
+			content, err := readRecordFile(file)
+			if err != nil {
+				return ex, err
+			}
+			if pair {
+				if content, err = recordOfPair(content, kind); err != nil {
+					return ex, err
+				}
+				if len(content) == 0 || string(content) == "null" {
+					continue
+				}
+			}
+			record := &exportRecord{kind: kind, file: file}
+			if err := json.Unmarshal(content, record); err != nil {
+				return ex, err
+			}
+			if record.Body, err = decodeBody(record.Body, record.BodyEncoding); err != nil {
+				return ex, err
+			}
+			if kind == "request" {
+				ex.Request = record
+			} else {
+				ex.Response = record
+			}
+		}
+	}
+	if ex.Request != nil && ex.Response != nil {
+		ex.Duration = time.Duration(ex.Response.DateUnixNano - ex.Request.DateUnixNano)
+	}
+	return ex, nil
+}
+
+var htmlFuncs = template.FuncMap{
+	"truncate": func(max int, s string) string {
+		if len(s) > max {
+			return strings.ToValidUTF8(s[:max], "") + "…"
+		}
+		return s
+	},
+	"join": strings.Join,
+}
+
+var bundleTemplate = template.Must(template.New("bundle").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gohrec bundle</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f4f4f4; padding: .5em; overflow-x: auto; white-space: pre-wrap; }
+.note { background: #fff6d5; padding: .5em; }
+</style>
+</head>
+<body>
+<h1>gohrec bundle</h1>
+<p>Generated on {{.Date.Format "2006-01-02 15:04:05 MST"}}, {{len .Exchanges}} exchange(s).</p>
+{{range .Exchanges}}
+<h2 id="{{.ID}}">{{with .Request}}{{.Method}} {{.Host}}{{.URI}}{{else}}{{.ID}}{{end}}</h2>
+<p>ID: <code>{{.ID}}</code>{{with .Response}}, status: <strong>{{.Status}}</strong>{{end}}{{if .Duration}}, duration: {{.Duration}}{{end}}</p>
+{{range .Annotations}}<p class="note">{{.Date.Format "2006-01-02 15:04:05"}} {{if .Labels}}[{{join .Labels ", "}}]{{end}} {{.Note}}</p>
+{{end}}
+{{with .Request}}<h3>Request, {{.DateUTC.Format "2006-01-02 15:04:05.000"}} UTC</h3>
+<pre>{{join .Headers "\n"}}</pre>
+{{if .Body}}<pre>{{truncate 65536 .Body}}</pre>{{end}}
+{{end}}
+{{with .Response}}<h3>Response, {{.DateUTC.Format "2006-01-02 15:04:05.000"}} UTC</h3>
+<pre>{{join .Headers "\n"}}</pre>
+{{if .Body}}<pre>{{truncate 65536 .Body}}</pre>{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func writeBundle(out string, exchanges []exchange) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	archive := zip.NewWriter(f)
+
+	for _, ex := range exchanges {
+		for _, file := range ex.files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			w, err := archive.CreateHeader(&zip.FileHeader{Name: "records/" + filepath.Base(file), Method: zip.Deflate, Modified: time.Now()})
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(content); err != nil {
+				return err
+			}
+		}
+	}
+
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: "summary.html", Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	err = bundleTemplate.Execute(w, struct {
+		Date      time.Time
+		Exchanges []exchange
+	}{time.Now(), exchanges})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func bundle() {
+	bundler := flag.NewFlagSet("bundle", flag.PanicOnError)
+	dir := bundler.String("dir", ".", "Directory of the records.")
+	ids := bundler.String("ids", "", "Comma-separated list of IDs of the records to bundle.")
+	out := bundler.String("out", "bundle.zip", "Zip file to write.")
+	bundler.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  ids: %s", *ids)
+	log.Printf("  out: %s", *out)
+
+	exchanges := []exchange{}
+	for _, id := range strings.Split(*ids, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		ex, err := loadExchange(*dir, id)
+		if err != nil {
+			log.Fatalf("Error while loading %s: %s", id, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	if len(exchanges) == 0 {
+		log.Fatal("--ids is required.")
+	}
+
+	if err := writeBundle(*out, exchanges); err != nil {
+		log.Fatalf("Error while writing bundle: %s", err)
+	}
+	log.Printf("Bundled %d exchange(s) into %s.", len(exchanges), *out)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// setCacheHeaders adds an ETag derived from the body hash and a Last-Modified
+// derived from the record date, unless they were recorded.
+func setCacheHeaders(header http.Header, bodyHash []byte, dateUnixNano int64) {
+	if header.Get("ETag") == "" {
+		header.Set("ETag", `"`+hex.EncodeToString(bodyHash[:16])+`"`)
+	}
+	if header.Get("Last-Modified") == "" && dateUnixNano != 0 {
+		header.Set("Last-Modified", time.Unix(0, dateUnixNano).UTC().Format(http.TimeFormat))
+	}
+}
+
+// isNotModified tells whether a conditional GET or HEAD request can be
+// answered with a 304, If-None-Match taking precedence over If-Modified-Since.
+func isNotModified(r *http.Request, header http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+		return !modified.After(since)
+	}
+	return false
+}
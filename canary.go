@@ -0,0 +1,198 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type canaryKey struct{}
+
+// canary duplicates a slice of the proxied traffic to a canary backend,
+// recording its responses along with their divergences from the primary ones.
+type canary struct {
+	url     *url.URL
+	percent float64
+	client  http.Client
+	running sync.WaitGroup
+}
+
+func makeCanary(rawURL string, percent float64, transport http.RoundTripper) (*canary, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid --canary-url `%s`, expected an absolute URL.", rawURL)
+	}
+	if percent <= 0 || percent > 100 {
+		return nil, fmt.Errorf("Invalid --canary-percent `%g`, expected a percentage in ]0, 100].", percent)
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &canary{
+		url:     u,
+		percent: percent,
+		client: http.Client{
+			Timeout:   60 * time.Second,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// selected returns the request carrying its body for the canary when it is
+// duplicated to the canary.
+func (c *canary) selected(r *http.Request, body []byte) *http.Request {
+	if c == nil || rand.Float64()*100 >= c.percent {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), canaryKey{}, body))
+}
+
+// wait waits for the requests sent to the canary to be recorded, up to a
+// timeout, like on shutdown.
+func (c *canary) wait(timeout time.Duration) {
+	if c == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Error while waiting for canary: requests left unrecorded.")
+	}
+}
+
+func canaryBodyOf(r *http.Request) ([]byte, bool) {
+	if r == nil {
+		return nil, false
+	}
+	body, ok := r.Context().Value(canaryKey{}).([]byte)
+	return body, ok
+}
+
+// canaryRecord is the response of the canary to a duplicated request, saved
+// with the ID of the request and compared to the response of the primary.
+type canaryRecord struct {
+	responseRecord
+	Canary      string
+	Duration    time.Duration
+	Error       string `json:",omitempty"`
+	Primary     targetResponse
+	Divergent   bool
+	Divergences []string
+}
+
+func makeTargetResponse(target string, resp *http.Response, body []byte) targetResponse {
+	hash := sha256.Sum256(body)
+	return targetResponse{
+		Target:      target,
+		Status:      resp.Status,
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		BodySize:    len(body),
+		BodySHA256:  hex.EncodeToString(hash[:]),
+	}
+}
+
+// runCanary sends the request forwarded to the primary to the canary, then
+// records its response and divergences.
+func (ghr goHRec) runCanary(req, reqid string, rt recordingTime, out *http.Request, primary targetResponse, body []byte) {
+	defer ghr.canary.running.Done()
+	target := *ghr.canary.url
+	target.Path = strings.TrimSuffix(target.Path, "/") + out.URL.Path
+	target.RawPath = ""
+	target.RawQuery = out.URL.RawQuery
+
+	record := canaryRecord{Canary: ghr.canary.url.String(), Primary: primary}
+	canaryResponse := targetResponse{Target: "canary"}
+	var canaryBody []byte
+
+	started := time.Now()
+	creq, err := http.NewRequest(out.Method, target.String(), bytes.NewReader(body))
+	if err == nil {
+		creq.Header = out.Header.Clone()
+		creq.Header.Del("X-Gohrec-Request-Id")
+		creq.Header.Del("X-Gohrec-Request-Received")
+		var resp *http.Response
+		if resp, err = ghr.canary.client.Do(creq); err == nil {
+			var bodyReader io.Reader = resp.Body
+			if ghr.maxBodySize != -1 {
+				bodyReader = io.LimitReader(resp.Body, ghr.maxBodySize)
+			}
+			canaryBody, err = ioutil.ReadAll(bodyReader)
+			resp.Body.Close()
+			canaryResponse = makeTargetResponse("canary", resp, canaryBody)
+			record.Protocol = resp.Proto
+			record.Headers = dumpValues(resp.Header)
+			record.ContentLength = resp.ContentLength
+			record.Trailers = dumpValues(resp.Trailer)
+			record.TransferEncodings = resp.TransferEncoding
+			record.Status = resp.Status
+			record.StatusCode = resp.StatusCode
+			record.Compressed = !resp.Uncompressed
+		}
+	}
+	received := time.Now()
+	record.Duration = received.Sub(started)
+	if err != nil {
+		record.Error = err.Error()
+		canaryResponse.Error = record.Error
+		record.Errors = append(record.Errors, ghr.logError(errorUpstream, "Error while sending to canary", err, "request", req))
+		metrics.Add("canary_errors", 1)
+	}
+
+	record.Divergences = compareResponses([]targetResponse{record.Primary, canaryResponse})
+	record.Divergent = len(record.Divergences) > 0
+	metrics.Add("canary_requests", 1)
+	if record.Divergent {
+		metrics.Add("canary_divergent", 1)
+		for _, divergence := range record.Divergences {
+			metrics.Add("canary_divergent_"+strings.SplitN(divergence, ":", 2)[0], 1)
+		}
+	}
+
+	record.ID = reqid
+	record.Date = received
+	record.DateUTC = received.UTC()
+	record.DateUnixNano = received.UnixNano()
+	record.CorrelationID = correlationID(out)
+	ghr.completeResponse(req, out.Method, out.URL.Path, &record.responseRecord, rt, ioutil.NopCloser(bytes.NewReader(canaryBody)))
+
+	content, err := json.MarshalIndent(record, "", " ")
+	if err != nil {
+		ghr.logError(errorStorage, "Error while serializing record", err, "request", req)
+		return
+	}
+	filename, _ := ghr.quarantined(record.Secrets, req).saveJSON(content, record.ID, rt.requestReceived, "canary", req)
+	level := slog.LevelInfo
+	if record.Divergent {
+		level = slog.LevelWarn
+	}
+	ghr.log(level, "Canary", "file", filename, "request", req, "id", record.ID, "status", record.StatusCode, "divergences", strings.Join(record.Divergences, ", "))
+}
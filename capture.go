@@ -0,0 +1,169 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var captureSessionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// captureSessionsMax is the number of capture sessions that can be open at
+// once, as each one grows its manifest with every record.
+const captureSessionsMax = 16
+
+// captureSessions are the named capture sessions currently open: the records
+// written while a session is open are tagged with its name and listed in its
+// manifest, written when it is closed.
+type captureSessions struct {
+	mutex sync.Mutex
+	open  map[string]*manifest
+}
+
+// names returns the names of the open sessions, sorted.
+func (cs *captureSessions) names() []string {
+	if cs == nil {
+		return nil
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	names := []string{}
+	for name := range cs.open {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (cs *captureSessions) add(name string, content []byte) {
+	if cs == nil {
+		return
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	for _, m := range cs.open {
+		m.add(name, content)
+	}
+}
+
+// list returns the open sessions with the number of records written so far.
+func (cs *captureSessions) list() []map[string]interface{} {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	sessions := []map[string]interface{}{}
+	for name, m := range cs.open {
+		m.mutex.Lock()
+		sessions = append(sessions, map[string]interface{}{"Name": name, "Started": m.Started, "Records": len(m.Files)})
+		m.mutex.Unlock()
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i]["Started"].(time.Time).Before(sessions[j]["Started"].(time.Time))
+	})
+	return sessions
+}
+
+// closeAll closes the open sessions, writing their manifests, like on
+// shutdown.
+func (cs *captureSessions) closeAll(dateFormat string, writeFile func(string, []byte) error, logError errorLogger) {
+	if cs == nil {
+		return
+	}
+	for _, name := range cs.names() {
+		cs.close(name, dateFormat, writeFile, logError)
+	}
+}
+
+// close closes a session and writes its manifest named after the session
+// start with the date format of records, returning nil if it is not open.
+func (cs *captureSessions) close(name, dateFormat string, writeFile func(string, []byte) error, logError errorLogger) *manifest {
+	cs.mutex.Lock()
+	m := cs.open[name]
+	delete(cs.open, name)
+	cs.mutex.Unlock()
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Stopped = time.Now()
+	m.writeTo(m.Started.Format(dateFormat)+name+".manifest.json", writeFile, logError)
+	return m
+}
+
+// captureSessionsHandler lists with GET the open capture sessions, and opens
+// with POST a session named like `{"name": "release-42"}`.
+func (ghr goHRec) captureSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, ghr.captureSessions.list())
+	case http.MethodPost:
+		var body struct{ Name string }
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !captureSessionName.MatchString(body.Name) {
+			http.Error(w, "Invalid session name, expected letters, digits, `.`, `_` and `-`.", http.StatusBadRequest)
+			return
+		}
+		cs := ghr.captureSessions
+		cs.mutex.Lock()
+		if _, ok := cs.open[body.Name]; ok {
+			cs.mutex.Unlock()
+			http.Error(w, "Session already open.", http.StatusConflict)
+			return
+		}
+		if len(cs.open) >= captureSessionsMax {
+			cs.mutex.Unlock()
+			http.Error(w, "Too many open sessions.", http.StatusTooManyRequests)
+			return
+		}
+		m := &manifest{Name: body.Name, Started: time.Now()}
+		cs.open[body.Name] = m
+		cs.mutex.Unlock()
+		ghr.log(slog.LevelInfo, "Capture session opened", "session", body.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeAdminJSON(w, map[string]interface{}{"Name": m.Name, "Started": m.Started})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// captureSessionHandler closes with DELETE a capture session, returning its
+// manifest.
+func (ghr goHRec) captureSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.PathValue("name")
+	m := ghr.captureSessions.close(name, ghr.dateFormat, ghr.writeFile, ghr.logError)
+	if m == nil {
+		http.Error(w, "Session not found.", http.StatusNotFound)
+		return
+	}
+	ghr.log(slog.LevelInfo, "Capture session closed", "session", name, "records", len(m.Files))
+	writeAdminJSON(w, m)
+}
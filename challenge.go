@@ -0,0 +1,125 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// challengeMaxBody is the maximum size of the bodies searched for a JSON
+// challenge.
+const challengeMaxBody = 1 << 20
+
+// challengeFlag is a `query:<name>`, `json:<path>` or `header:<name>` webhook
+// verification challenge, answered by echoing its value.
+type challengeFlag struct {
+	kind, name string
+	path       jsonPath
+}
+
+func (cf *challengeFlag) Set(value string) error {
+	split := strings.SplitN(value, ":", 2)
+	if len(split) != 2 || split[1] == "" {
+		return fmt.Errorf("Invalid challenge `%s`, expected `query:<name>`, `json:<path>` or `header:<name>`.", value)
+	}
+	cf.kind, cf.name = split[0], split[1]
+	switch cf.kind {
+	case "query":
+	case "header":
+		cf.name = http.CanonicalHeaderKey(cf.name)
+	case "json":
+		if !strings.HasPrefix(cf.name, "$") {
+			cf.name = "$." + cf.name
+		}
+		path, err := parseJSONPath(cf.name)
+		if err != nil {
+			return err
+		}
+		cf.path = path
+	default:
+		return fmt.Errorf("Invalid challenge `%s`, expected `query:<name>`, `json:<path>` or `header:<name>`.", value)
+	}
+	return nil
+}
+
+func (cf *challengeFlag) String() string {
+	return cf.kind + ":" + cf.name
+}
+
+type arrayChallengeFlag []challengeFlag
+
+func (acf *arrayChallengeFlag) Set(value string) error {
+	item := challengeFlag{}
+	if err := item.Set(value); err != nil {
+		return err
+	}
+	*acf = append(*acf, item)
+	return nil
+}
+
+func (acf *arrayChallengeFlag) String() string {
+	if acf == nil {
+		return "[]"
+	}
+	out := []string{}
+	for _, item := range *acf {
+		out = append(out, "`"+item.String()+"`")
+	}
+	return "[ " + strings.Join(out, ", ") + " ]"
+}
+
+// answer answers the first challenge found in a request, returning it, or
+// returns an empty string if there is none. The body of the request is
+// preserved.
+func (acf arrayChallengeFlag) answer(w http.ResponseWriter, r *http.Request) string {
+	var doc interface{}
+	for _, item := range acf {
+		if item.kind == "json" && doc == nil {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, challengeMaxBody))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if err != nil {
+				return ""
+			}
+			if doc, _ = decodeJSON(string(body)); doc == nil {
+				doc = false
+			}
+		}
+
+		var value string
+		switch item.kind {
+		case "query":
+			value = r.URL.Query().Get(item.name)
+		case "json":
+			for _, found := range item.path.lookup(doc) {
+				if s, ok := found.(string); ok {
+					value = s
+					break
+				}
+			}
+		case "header":
+			if value = r.Header.Get(item.name); value != "" {
+				w.Header().Set(item.name, value)
+				w.WriteHeader(http.StatusOK)
+				return item.String()
+			}
+		}
+		if value != "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(value))
+			return item.String()
+		}
+	}
+	return ""
+}
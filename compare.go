@@ -0,0 +1,162 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type targetResponse struct {
+	Target      string
+	Error       string `json:",omitempty"`
+	Status      string
+	StatusCode  int
+	ContentType string
+	BodySize    int
+	BodySHA256  string
+	Body        string
+	Duration    time.Duration
+	Redirects   []string `json:",omitempty"`
+}
+
+type comparisonEntry struct {
+	Request     string
+	Method, URI string
+	Divergent   bool
+	Divergences []string
+	Responses   []targetResponse
+}
+
+// comparisonReport is written by --compare-report.
+type comparisonReport struct {
+	Targets              []string
+	Requests, Divergents int
+	Entries              []comparisonEntry
+}
+
+type comparison struct {
+	targets   []string
+	mutex     sync.Mutex
+	entries   []comparisonEntry
+	divergent int
+}
+
+func (rd redoer) fetch(record redoRecord, target string) targetResponse {
+	tr := targetResponse{Target: target}
+
+	req, err := rd.prepare(record, target)
+	if err != nil {
+		tr.Error = err.Error()
+		return tr
+	}
+
+	start := time.Now()
+	resp, err := rd.client.Do(req)
+	if err != nil {
+		tr.Error = err.Error()
+		tr.Duration = time.Since(start)
+		return tr
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	tr.Duration = time.Since(start)
+	if err != nil {
+		tr.Error = err.Error()
+	}
+	hash := sha256.Sum256(body)
+	tr.Redirects = redirectChain(resp)
+	tr.Status = resp.Status
+	tr.StatusCode = resp.StatusCode
+	tr.ContentType = resp.Header.Get("Content-Type")
+	tr.BodySize = len(body)
+	tr.BodySHA256 = hex.EncodeToString(hash[:])
+	tr.Body = string(body)
+	return tr
+}
+
+func compareResponses(responses []targetResponse) []string {
+	divergences := []string{}
+	if len(responses) < 2 {
+		return divergences
+	}
+	reference := responses[0]
+	for _, other := range responses[1:] {
+		if other.Error != reference.Error {
+			divergences = append(divergences, "error: "+reference.Target+" != "+other.Target)
+		}
+		if other.StatusCode != reference.StatusCode {
+			divergences = append(divergences, "status: "+reference.Target+" != "+other.Target)
+		}
+		if other.ContentType != reference.ContentType {
+			divergences = append(divergences, "content-type: "+reference.Target+" != "+other.Target)
+		}
+		if other.BodySHA256 != reference.BodySHA256 {
+			divergences = append(divergences, "body: "+reference.Target+" != "+other.Target)
+		}
+	}
+	return divergences
+}
+
+// compare sends the request to every target and stores their responses and divergences.
+func (rd redoer) compare(file redoFile) {
+	entry := comparisonEntry{
+		Request: file.name,
+		Method:  file.record.Method,
+		URI:     file.record.URI,
+	}
+	for _, target := range rd.comparison.targets {
+		entry.Responses = append(entry.Responses, rd.fetch(file.record, target))
+	}
+	entry.Divergences = compareResponses(entry.Responses)
+	entry.Divergent = len(entry.Divergences) > 0
+
+	statuses := []string{}
+	for _, response := range entry.Responses {
+		if response.Error != "" {
+			statuses = append(statuses, response.Target+"=error")
+		} else {
+			statuses = append(statuses, response.Target+"="+response.Status)
+		}
+	}
+	if entry.Divergent {
+		log.Printf("Divergent: %s %s [%s] (%s)", entry.Method, entry.URI, strings.Join(statuses, ", "), strings.Join(entry.Divergences, "; "))
+	} else {
+		log.Printf("Identical: %s %s [%s]", entry.Method, entry.URI, strings.Join(statuses, ", "))
+	}
+
+	rd.comparison.mutex.Lock()
+	defer rd.comparison.mutex.Unlock()
+	rd.comparison.entries = append(rd.comparison.entries, entry)
+	if entry.Divergent {
+		rd.comparison.divergent++
+	}
+}
+
+func (c *comparison) report(file string) {
+	log.Printf("Compared %d request(s) against %d target(s): %d divergent", len(c.entries), len(c.targets), c.divergent)
+
+	if file == "" {
+		return
+	}
+	report := comparisonReport{c.targets, len(c.entries), c.divergent, c.entries}
+	content, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		log.Printf("Error while serializing comparison report: %s", err)
+		return
+	}
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		log.Printf("Error while writing comparison report: %s", err)
+		return
+	}
+	log.Printf("Comparison report written to %s", file)
+}
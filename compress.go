@@ -0,0 +1,96 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var compressExtensions = map[string]string{
+	"":     "",
+	"gzip": ".gz",
+}
+
+func checkCompression(format string) error {
+	if _, ok := compressExtensions[format]; !ok {
+		return fmt.Errorf("Unknown compression `%s`, expected `gzip`.", format)
+	}
+	return nil
+}
+
+func compressRecord(format string, content []byte) ([]byte, error) {
+	switch format {
+	case "gzip":
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+	return content, nil
+}
+
+// readRecordFile reads a record file, decompressing it if needed.
+func readRecordFile(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 2 && content[0] == 0x1f && content[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+	return content, nil
+}
+
+// isRecordFile tells if the file is a record of the specified kind, like `request`.
+func isRecordFile(file string, kind string) bool {
+	suffix := "." + kind + ".json"
+	for _, ext := range compressExtensions {
+		if strings.HasSuffix(file, suffix+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// openRecordFile opens a record file, decompressing it on the fly if needed.
+func openRecordFile(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(f)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gz, f}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, f}, nil
+}
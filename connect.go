@@ -0,0 +1,103 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const connectDialTimeout = 30 * time.Second
+
+// upstream returns the URL requests are forwarded to: the one of the first
+// matching route, --target-url, or in dynamic mode the one derived from the
+// absolute-form URI or Host header.
+func (ghr goHRec) upstream(r *http.Request) *url.URL {
+	if target := ghr.routes.Match(r); target != nil {
+		return target
+	}
+	if !ghr.proxyDynamic {
+		return ghr.targetURL
+	}
+	if r.URL.IsAbs() {
+		return &url.URL{Scheme: r.URL.Scheme, Host: r.URL.Host}
+	}
+	return &url.URL{Scheme: "http", Host: r.Host}
+}
+
+// connectHandler tunnels CONNECT requests to their destination. Only the
+// CONNECT request itself is recorded, the tunneled traffic being opaque,
+// unless it is intercepted with the MITM CA.
+func (ghr goHRec) connectHandler(w http.ResponseWriter, r *http.Request) {
+	rt := recordingTime{requestReceived: time.Now()}
+	req := makeRequestName(r)
+
+	if ghr.isLooping(r, req) {
+		w.WriteHeader(http.StatusLoopDetected)
+		fmt.Fprintln(w, "Skipped: loop detected.")
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Tunneling not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	if ghr.mitm != nil {
+		client, _, err := hijacker.Hijack()
+		if err != nil {
+			ghr.logError(errorClientAbort, "Error while hijacking connection", err, "request", req)
+			return
+		}
+		fmt.Fprint(client, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		ghr.intercept(client, r.Host)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", r.Host, connectDialTimeout)
+	if err != nil {
+		ghr.logError(errorUpstream, "Error while connecting to upstream", err, "request", req, "upstream", r.Host)
+		http.Error(w, "Cannot connect to upstream.", http.StatusBadGateway)
+		return
+	}
+
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		ghr.logError(errorClientAbort, "Error while hijacking connection", err, "request", req)
+		return
+	}
+	fmt.Fprint(client, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+	if !ghr.isNotWhitelisted(r, req) && !ghr.isBlacklisted(r, req) {
+		rt.requestForwarded = time.Now()
+		ghr.saveRequest(req, ghr.prepareRequestRecord(r, rt), rt, strings.NewReader(""))
+	}
+
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+// dynamicHandler routes CONNECT requests to the tunnel, and all others to next.
+func (ghr goHRec) dynamicHandler(next http.Handler, limiter *rateLimiter) http.Handler {
+	connect := limiter.wrap(ghr, ghr.connectHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			connect(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	traceparentPattern  = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+	safeRecordIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+	zeroTraceID         = strings.Repeat("0", 32)
+)
+
+// correlationID returns the X-Request-Id of a request, or else the trace ID
+// of its W3C traceparent.
+func correlationID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get("X-Request-Id")); id != "" {
+		return id
+	}
+	if match := traceparentPattern.FindStringSubmatch(strings.TrimSpace(r.Header.Get("Traceparent"))); match != nil && match[1] != zeroTraceID {
+		return match[1]
+	}
+	return ""
+}
+
+// requestID returns the correlation ID of a request as its record ID when
+// --correlation-id-as-record-id is set and it is safe to use in filenames, a
+// generated ID otherwise.
+func (ghr goHRec) requestID(r *http.Request, req string, received time.Time) string {
+	if ghr.correlationAsID {
+		if id := correlationID(r); safeRecordIDPattern.MatchString(id) {
+			return id
+		}
+	}
+	return ghr.makeRequestID(req, received)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// curlSkippedHeaders are computed by curl or only relevant to gohrec.
+var curlSkippedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// shellQuote quotes a string for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// printfQuote quotes a string for the `%b` format of printf, non-printable
+// bytes being escaped in octal, so that binary content survives the shell.
+func printfQuote(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			out.WriteString(`\\`)
+		case c >= 0x20 && c < 0x7f:
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, `\0%03o`, c)
+		}
+	}
+	return shellQuote(out.String())
+}
+
+// curlCommand returns a curl command line sending a request, binary bodies
+// being piped to it.
+func curlCommand(method, url string, headers []string, body string) string {
+	args := []string{"curl"}
+	if method != "GET" || body != "" {
+		args = append(args, "-X", shellQuote(method))
+	}
+	args = append(args, shellQuote(url))
+	for _, header := range headers {
+		split := strings.SplitN(header, ": ", 2)
+		if len(split) != 2 || curlSkippedHeaders[split[0]] || strings.HasPrefix(split[0], "X-Gohrec-") {
+			continue
+		}
+		args = append(args, "-H", shellQuote(header))
+	}
+	if body != "" && isPrintable(body) {
+		args = append(args, "--data-binary", shellQuote(body))
+	} else if body != "" {
+		args = append([]string{"printf", "'%b'", printfQuote(body), "|"}, append(args, "--data-binary", "@-")...)
+	}
+	return strings.Join(args, " ")
+}
+
+// exportCurl writes request records as curl command lines.
+func exportCurl(records []exportRecord, out io.Writer) error {
+	for _, record := range records {
+		command := curlCommand(record.Method, recordURL(record).String(), record.Headers, record.Body)
+		if _, err := fmt.Fprintln(out, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
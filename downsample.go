@@ -0,0 +1,146 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downsampledExchange is a recorded exchange, made of the record files of an
+// ID.
+type downsampledExchange struct {
+	id       string
+	date     int64
+	endpoint string
+	status   int
+	labeled  bool
+	files    []string
+}
+
+// groupExchanges groups index entries by record ID, in their recording order.
+func groupExchanges(entries []indexEntry) []*downsampledExchange {
+	exchanges := []*downsampledExchange{}
+	byID := map[string]*downsampledExchange{}
+	for _, entry := range entries {
+		exchange, ok := byID[entry.id]
+		if !ok {
+			exchange = &downsampledExchange{id: entry.id, date: entry.date}
+			byID[entry.id] = exchange
+			exchanges = append(exchanges, exchange)
+		}
+		if exchange.endpoint == "" && entry.req != "" {
+			if fields := strings.SplitN(entry.req, " ", 3); len(fields) == 3 {
+				path, _ := templatePath(pathOfRequestName(entry.req))
+				exchange.endpoint = fields[1] + " " + path
+			}
+		}
+		for _, status := range entry.values["status"] {
+			exchange.status, _ = strconv.Atoi(status)
+		}
+		exchange.labeled = exchange.labeled || len(entry.values["label"]) > 0
+		exchange.files = append(exchange.files, entry.file)
+	}
+	return exchanges
+}
+
+// selectDownsampled returns the exchanges older than the cutoff to remove,
+// keeping every nth one of each endpoint, and the annotated ones, the ones
+// failing with a 4xx or 5xx response or without response too if keepErrors.
+func selectDownsampled(exchanges []*downsampledExchange, cutoff time.Time, every int, keepErrors bool) []*downsampledExchange {
+	removed := []*downsampledExchange{}
+	seen := map[string]int{}
+	for _, exchange := range exchanges {
+		if exchange.date >= cutoff.UnixNano() {
+			continue
+		}
+		if exchange.labeled || (keepErrors && (exchange.status == 0 || exchange.status >= 400)) {
+			continue
+		}
+		count := seen[exchange.endpoint]
+		seen[exchange.endpoint]++
+		if every > 0 && count%every == 0 {
+			continue
+		}
+		removed = append(removed, exchange)
+	}
+	return removed
+}
+
+func downsample() {
+	downsampler := flag.NewFlagSet("downsample", flag.PanicOnError)
+	dir := downsampler.String("dir", ".", "Directory of the records.")
+	olderThan := downsampler.Duration("older-than", 0, "Age (like `720h`) past which records are downsampled.")
+	keepEvery := downsampler.Int("keep-every", 10, "Keep every nth record of each endpoint, `0` to keep none.")
+	keepErrors := downsampler.Bool("keep-errors", false, "Keep all the records of exchanges failing with a 4xx or 5xx response or without response.")
+	dryRun := downsampler.Bool("dry-run", false, "Only list the record files that would be removed.")
+	downsampler.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  older-than: %s", *olderThan)
+	log.Printf("  keep-every: %d", *keepEvery)
+	log.Printf("  keep-errors: %t", *keepErrors)
+	log.Printf("  dry-run: %t", *dryRun)
+
+	if *olderThan <= 0 {
+		log.Fatal("--older-than is required.")
+	}
+	if *keepEvery < 0 {
+		log.Fatal("--keep-every cannot be negative.")
+	}
+
+	entries, err := scanIndexEntries(*dir)
+	if err != nil {
+		log.Fatalf("Error while reading records: %s", err)
+	}
+	exchanges := groupExchanges(entries)
+	selected := selectDownsampled(exchanges, time.Now().Add(-*olderThan), *keepEvery, *keepErrors)
+
+	removed := map[string]bool{}
+	for _, exchange := range selected {
+		files := exchange.files
+		if i := strings.Index(files[0], "."+exchange.id+"."); i > -1 {
+			files = append(files, files[0][:i]+"."+exchange.id+".annotations.json")
+		}
+		for _, file := range files {
+			path := filepath.Join(*dir, file)
+			if *dryRun {
+				if fileExists(path) {
+					log.Printf("Would remove %s", path)
+				}
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				if !os.IsNotExist(err) {
+					log.Printf("Error while removing %s: %s", path, err)
+				}
+				continue
+			}
+			removed[filepath.Clean(path)] = true
+		}
+	}
+
+	if *dryRun {
+		log.Printf("Would downsample %d of %d exchange(s).", len(selected), len(exchanges))
+		return
+	}
+	removeEmptyDirs(*dir, removed)
+	if fileExists(filepath.Join(*dir, "index.log")) {
+		if err := compactIndex(*dir, "index.log", 1, false); err != nil {
+			log.Fatalf("Error while compacting index.log: %s", err)
+		}
+		for _, key := range secondaryIndexes {
+			if err := compactIndex(*dir, "index."+key+".log", 2, true); err != nil {
+				log.Fatalf("Error while compacting index.%s.log: %s", key, err)
+			}
+		}
+	}
+	log.Printf("Downsampled %d of %d exchange(s): %d file(s) removed.", len(selected), len(exchanges), len(removed))
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	esBatchSize     = 500
+	esFlushInterval = time.Second
+)
+
+// esDatePattern matches the `%{+yyyy.MM.dd}` date patterns of index names.
+var esDatePattern = regexp.MustCompile(`%\{\+([^}]+)\}`)
+
+var esDateTokens = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15", "mm", "04", "ss", "05")
+
+// esSink bulk-indexes records into Elasticsearch or OpenSearch, flushing
+// batches when they are full or every second.
+type esSink struct {
+	url      string
+	index    string
+	client   http.Client
+	logError errorLogger
+	mutex    sync.Mutex
+	batch    bytes.Buffer
+	count    int
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func makeESSink(url, index, via string, logError errorLogger) (*esSink, error) {
+	if url == "" || index == "" {
+		return nil, fmt.Errorf("--sink elasticsearch requires --es-url and --es-index.")
+	}
+	es := &esSink{
+		url:      strings.TrimSuffix(url, "/"),
+		index:    index,
+		client:   http.Client{Timeout: 30 * time.Second, Transport: makeViaTransport(via, nil)},
+		logError: logError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go es.run()
+	return es, nil
+}
+
+// indexName returns the index of a record, formatting the date patterns of
+// the index name with its date.
+func (es *esSink) indexName(date time.Time) string {
+	return esDatePattern.ReplaceAllStringFunc(es.index, func(pattern string) string {
+		return date.UTC().Format(esDateTokens.Replace(esDatePattern.FindStringSubmatch(pattern)[1]))
+	})
+}
+
+func (es *esSink) publish(record sinkRecord) error {
+	var doc bytes.Buffer
+	if err := json.Compact(&doc, record.Content); err != nil {
+		return err
+	}
+	action, err := json.Marshal(map[string]map[string]string{
+		"index": {"_index": es.indexName(record.Date), "_id": record.ID + "." + record.Kind},
+	})
+	if err != nil {
+		return err
+	}
+
+	es.mutex.Lock()
+	es.batch.Write(action)
+	es.batch.WriteByte('\n')
+	es.batch.Write(doc.Bytes())
+	es.batch.WriteByte('\n')
+	es.count++
+	full := es.count >= esBatchSize
+	es.mutex.Unlock()
+
+	if full {
+		es.flush()
+	}
+	return nil
+}
+
+// flush sends the pending batch with the bulk API.
+func (es *esSink) flush() {
+	es.mutex.Lock()
+	if es.count == 0 {
+		es.mutex.Unlock()
+		return
+	}
+	body, count := append([]byte{}, es.batch.Bytes()...), es.count
+	es.batch.Reset()
+	es.count = 0
+	es.mutex.Unlock()
+
+	failed, err := es.bulk(body)
+	if err != nil {
+		failed = count
+		es.logError(errorStorage, "Error while indexing records", err, "records", count)
+	}
+	metrics.Add("records_failed", int64(failed))
+}
+
+// bulk posts a batch and returns the number of records that failed.
+func (es *esSink) bulk(body []byte) (int, error) {
+	resp, err := es.client.Post(es.url+"/_bulk", "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("unexpected status %s: %.200s", resp.Status, content)
+	}
+
+	var result struct {
+		Errors bool
+		Items  []map[string]struct {
+			Status int
+			Error  json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return 0, err
+	}
+	failed := 0
+	if result.Errors {
+		for _, item := range result.Items {
+			for _, action := range item {
+				if action.Status >= 300 {
+					if failed == 0 {
+						es.logError(errorStorage, "Error while indexing record", errors.New(string(action.Error)))
+					}
+					failed++
+				}
+			}
+		}
+	}
+	return failed, nil
+}
+
+func (es *esSink) run() {
+	defer close(es.done)
+	ticker := time.NewTicker(esFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			es.flush()
+		case <-es.stop:
+			es.flush()
+			return
+		}
+	}
+}
+
+func (es *esSink) close() error {
+	close(es.stop)
+	<-es.done
+	return nil
+}
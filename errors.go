@@ -0,0 +1,66 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// Categories of the errors met while recording, logged in their `category`
+// field, counted by the `errors_<category>` metrics and listed in the
+// `Errors` of the records they affect.
+const (
+	errorStorage     = "storage"
+	errorRedaction   = "redaction"
+	errorUpstream    = "upstream"
+	errorClientAbort = "client-abort"
+	errorParse       = "parse"
+)
+
+// recordError is an error met while recording an exchange.
+type recordError struct {
+	Category string
+	Message  string
+}
+
+// errorLogger logs and counts an error of a category, like logError, for the
+// components running on their own like the tracer.
+type errorLogger func(category, msg string, err error, fields ...interface{}) recordError
+
+// logError logs an error of a category and counts it, returning it to be
+// added to the record it affects, if any.
+func (ghr goHRec) logError(category, msg string, err error, fields ...interface{}) recordError {
+	metrics.Add("errors_"+category, 1)
+	ghr.log(slog.LevelError, msg, append([]interface{}{"category", category, "error", err}, fields...)...)
+	return recordError{Category: category, Message: msg + ": " + err.Error()}
+}
+
+// bodyErrorCategory tells whether an error reading a body was caused by its
+// sender going away or by an invalid encoding, like a malformed chunk.
+func bodyErrorCategory(err error) string {
+	var netErr net.Error
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.Canceled) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr) {
+		return errorClientAbort
+	}
+	return errorParse
+}
+
+// proxyErrorHandler answers with a 502 the requests whose upstream failed,
+// adding the error to their record.
+func (ghr goHRec) proxyErrorHandler(req string, record *requestRecord) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		category := errorUpstream
+		if r.Context().Err() != nil {
+			category = errorClientAbort
+		}
+		record.Errors = append(record.Errors, ghr.logError(category, "Error while proxying", err, "request", req))
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
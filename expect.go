@@ -0,0 +1,96 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// expectations are assertions on the responses of redone requests, for
+// smoke tests failing with a non-zero exit code.
+type expectations struct {
+	status       int
+	bodyContains []string
+	headers      [][2]string
+	mutex        sync.Mutex
+	passed       int
+	failed       int
+}
+
+func makeExpectations(status int, bodyContains, headers []string) (*expectations, error) {
+	if status == 0 && len(bodyContains) == 0 && len(headers) == 0 {
+		return nil, nil
+	}
+	if status != 0 && (status < 100 || status > 599) {
+		return nil, fmt.Errorf("Invalid --expect-status `%d`, expected a status code.", status)
+	}
+	e := &expectations{status: status, bodyContains: bodyContains}
+	for _, header := range headers {
+		split := strings.SplitN(header, ":", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" {
+			return nil, fmt.Errorf("Invalid --expect-header `%s`, expected `Name: value`.", header)
+		}
+		e.headers = append(e.headers, [2]string{http.CanonicalHeaderKey(strings.TrimSpace(split[0])), strings.TrimSpace(split[1])})
+	}
+	return e, nil
+}
+
+// check asserts the response to a request, logging and counting the outcome.
+func (e *expectations) check(req *http.Request, resp *http.Response, body []byte) {
+	failures := []string{}
+	if e.status != 0 && resp.StatusCode != e.status {
+		failures = append(failures, fmt.Sprintf("status: expected `%d`, got `%d`", e.status, resp.StatusCode))
+	}
+	for _, header := range e.headers {
+		found := false
+		for _, value := range resp.Header.Values(header[0]) {
+			found = found || strings.TrimSpace(value) == header[1]
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("header %s: expected `%s`, got `%s`", header[0], header[1], strings.Join(resp.Header.Values(header[0]), ", ")))
+		}
+	}
+	for _, text := range e.bodyContains {
+		if !strings.Contains(string(body), text) {
+			failures = append(failures, fmt.Sprintf("body: expected to contain `%s`", text))
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(failures) > 0 {
+		e.failed++
+		log.Printf("Expectations failed: %s %s (%s)", req.Method, req.URL, strings.Join(failures, "; "))
+		return
+	}
+	e.passed++
+	log.Printf("Expectations passed: %s %s", req.Method, req.URL)
+}
+
+// fail counts a request that got no response.
+func (e *expectations) fail(req *http.Request, err error) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.failed++
+	log.Printf("Expectations failed: %s %s (error: %s)", req.Method, req.URL, err)
+}
+
+// report logs the outcome of all checks and returns the number of failed ones.
+func (e *expectations) report() int {
+	if e == nil {
+		return 0
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	log.Printf("Checked %d response(s): %d passed, %d failed", e.passed+e.failed, e.passed, e.failed)
+	return e.failed
+}
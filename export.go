@@ -0,0 +1,251 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportRecord holds the fields of both request and response records.
+type exportRecord struct {
+	ID                      string
+	DateUTC                 time.Time
+	DateUnixNano            int64
+	Protocol                string
+	Headers                 []string
+	ContentLength           int64
+	Body                    string
+	BodyEncoding            string
+	SessionID               string
+	CorrelationID           string
+	RemoteAddr              string
+	Host, Method, Path, URI string
+	Query                   []string
+	Status                  string
+	StatusCode              int
+	kind, file              string
+}
+
+func loadExportRecords(dir string, kinds ...string) ([]exportRecord, error) {
+	records := []exportRecord{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		for _, kind := range kinds {
+			pair := isRecordFile(path, "pair")
+			if !isRecordFile(path, kind) && !pair {
+				continue
+			}
+			content, err := readRecordFile(path)
+			if err != nil {
+				return err
+			}
+			if pair {
+				if content, err = recordOfPair(content, kind); err != nil {
+					log.Printf("Error while unmarshalling %s: %s", path, err)
+					return nil
+				}
+				if len(content) == 0 || string(content) == "null" {
+					continue
+				}
+			}
+			record := exportRecord{kind: kind, file: path}
+			if err := json.Unmarshal(content, &record); err != nil {
+				log.Printf("Error while unmarshalling %s: %s", path, err)
+				return nil
+			}
+			if record.Body, err = decodeBody(record.Body, record.BodyEncoding); err != nil {
+				log.Printf("Error while decoding body of %s: %s", path, err)
+				return nil
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].DateUnixNano < records[j].DateUnixNano
+	})
+	return records, err
+}
+
+var identifierSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{16,}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|.+@.+)$`)
+
+type anonymizer struct {
+	key []byte
+}
+
+func (a anonymizer) hash(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func (a anonymizer) path(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if identifierSegment.MatchString(segment) {
+			segments[i] = "{" + a.hash(segment) + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func generalizeIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+type analyticsRecord struct {
+	Kind          string
+	ID            string
+	Time          time.Time
+	Method        string `json:",omitempty"`
+	Host          string `json:",omitempty"`
+	Path          string `json:",omitempty"`
+	QueryKeys     []string
+	HeaderNames   []string
+	ContentLength int64
+	StatusCode    int    `json:",omitempty"`
+	ClientNetwork string `json:",omitempty"`
+}
+
+func (a anonymizer) analytics(record exportRecord, bucket time.Duration) analyticsRecord {
+	out := analyticsRecord{
+		Kind:          record.kind,
+		ID:            a.hash(record.ID),
+		Time:          record.DateUTC.Truncate(bucket),
+		Method:        record.Method,
+		Host:          record.Host,
+		Path:          a.path(record.Path),
+		QueryKeys:     []string{},
+		HeaderNames:   []string{},
+		ContentLength: record.ContentLength,
+		StatusCode:    record.StatusCode,
+		ClientNetwork: generalizeIP(record.RemoteAddr),
+	}
+	for _, query := range record.Query {
+		out.QueryKeys = append(out.QueryKeys, strings.SplitN(query, ": ", 2)[0])
+	}
+	for _, header := range record.Headers {
+		out.HeaderNames = append(out.HeaderNames, strings.SplitN(header, ": ", 2)[0])
+	}
+	return out
+}
+
+func exportAnalytics(records []exportRecord, out io.Writer, bucket time.Duration, key []byte) error {
+	a := anonymizer{key: key}
+	encoder := json.NewEncoder(out)
+	for _, record := range records {
+		if err := encoder.Encode(a.analytics(record, bucket)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func export() {
+	exporter := flag.NewFlagSet("export", flag.PanicOnError)
+	dir := exporter.String("dir", ".", "Directory of the records to export.")
+	format := exporter.String("format", "analytics", "Export format: `analytics` (privacy-reduced traffic metadata as JSON lines) `parquet` (Parquet file of request and response records, for SQL engines), `postman` (Postman v2.1 collection of requests) or `curl` (curl command lines of requests).")
+	out := exporter.String("out", "", "File where the export is written, standard output if empty.")
+	timeBucket := exporter.Duration("time-bucket", time.Hour, "With `analytics` format, timestamps are truncated to this duration.")
+	hashKey := exporter.String("hash-key", "", "With `analytics` format or --pseudonymize, key used to hash identifiers consistently, random if empty.")
+	pseudonymize := exporter.String("pseudonymize", "", "If set, comma-separated list of identifier kinds replaced by consistent pseudonyms: `email`, `uuid`, `path-id`.")
+	pseudonymizeFields := exporter.String("pseudonymize-fields", "", "If set, comma-separated list of JSON fields, query parameters and headers (like `user_id,X-User-Id`) whose values are replaced by consistent pseudonyms.")
+	name := exporter.String("name", "gohrec", "With `postman` format, name of the collection.")
+	exporter.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  format: %s", *format)
+	log.Printf("  out: %s", *out)
+	log.Printf("  time-bucket: %s", *timeBucket)
+	log.Printf("  name: %s", *name)
+	log.Printf("  pseudonymize: %s", *pseudonymize)
+	log.Printf("  pseudonymize-fields: %s", *pseudonymizeFields)
+
+	key := []byte(*hashKey)
+	if *hashKey == "" {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	pseudonymizer, err := makePseudonymizer(*pseudonymize, *pseudonymizeFields, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	load := func(kinds ...string) ([]exportRecord, error) {
+		records, err := loadExportRecords(*dir, kinds...)
+		for i := range records {
+			pseudonymizer.record(&records[i])
+		}
+		return records, err
+	}
+
+	var writer io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Error while creating %s: %s", *out, err)
+		}
+		defer f.Close()
+		writer = f
+	}
+
+	var records []exportRecord
+	switch *format {
+	case "analytics":
+		if records, err = load("request", "response"); err == nil {
+			err = exportAnalytics(records, writer, *timeBucket, key)
+		}
+	case "parquet":
+		if records, err = load("request", "response"); err == nil {
+			err = exportParquet(records, writer)
+		}
+	case "curl":
+		if records, err = load("request"); err == nil {
+			err = exportCurl(records, writer)
+		}
+	case "postman":
+		if records, err = load("request"); err == nil {
+			err = exportPostman(records, writer, *name)
+		}
+	default:
+		log.Fatalf("Unknown export format `%s`.", *format)
+	}
+	if err != nil {
+		log.Fatalf("Error while exporting: %s", err)
+	}
+	log.Printf("Exported %d record(s).", len(records))
+}
@@ -0,0 +1,650 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filterFields are the fields of the variables of filter expressions.
+var filterFields = map[string]map[string]bool{
+	"request":  {"method": true, "path": true, "host": true, "uri": true, "query": true, "remote": true, "headers": true},
+	"response": {"status": true, "headers": true},
+}
+
+type filterToken struct {
+	kind string // ident, string, number, op or eof
+	text string
+	pos  int
+}
+
+type filterNode func(env map[string]interface{}) (interface{}, error)
+
+// filterExpression is a CEL-like boolean expression over the request and
+// response of an exchange, like `request.method == "POST" && response.status >= 500`.
+type filterExpression struct {
+	raw          string
+	root         filterNode
+	usesResponse bool
+	tokens       []filterToken
+	i            int
+}
+
+func lexFilter(raw string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			start := i
+			for i < len(raw) && (raw[i] == '_' || (raw[i] >= 'a' && raw[i] <= 'z') || (raw[i] >= 'A' && raw[i] <= 'Z') || (raw[i] >= '0' && raw[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, filterToken{"ident", raw[start:i], start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(raw) && ((raw[i] >= '0' && raw[i] <= '9') || raw[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, filterToken{"number", raw[start:i], start})
+		case c == '"' || c == '\'':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(raw) && raw[i] != c; i++ {
+				if raw[i] == '\\' && i+1 < len(raw) {
+					i++
+					switch raw[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(raw[i])
+					}
+					continue
+				}
+				sb.WriteByte(raw[i])
+			}
+			if i >= len(raw) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, filterToken{"string", sb.String(), start})
+		default:
+			if i+1 < len(raw) {
+				if op := raw[i : i+2]; op == "&&" || op == "||" || op == "==" || op == "!=" || op == "<=" || op == ">=" {
+					tokens = append(tokens, filterToken{"op", op, i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("!<>()[].,-", rune(c)) {
+				return nil, fmt.Errorf("unexpected character `%c` at %d", c, i)
+			}
+			tokens = append(tokens, filterToken{"op", string(c), i})
+			i++
+		}
+	}
+	return append(tokens, filterToken{"eof", "", len(raw)}), nil
+}
+
+// compileFilter parses an expression once, so that evaluating it per
+// exchange is cheap.
+func compileFilter(raw string) (*filterExpression, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	tokens, err := lexFilter(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --filter: %s.", err)
+	}
+	fe := &filterExpression{raw: raw, tokens: tokens}
+	root, _, err := fe.parseOr()
+	if err == nil && fe.peek().kind != "eof" {
+		err = fmt.Errorf("unexpected `%s` at %d", fe.peek().text, fe.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --filter: %s.", err)
+	}
+	fe.root, fe.tokens = root, nil
+	return fe, nil
+}
+
+func (fe *filterExpression) peek() filterToken {
+	return fe.tokens[fe.i]
+}
+
+func (fe *filterExpression) accept(text string) bool {
+	if token := fe.peek(); token.kind == "op" || token.kind == "ident" {
+		if token.text == text {
+			fe.i++
+			return true
+		}
+	}
+	return false
+}
+
+func (fe *filterExpression) expect(text string) error {
+	if !fe.accept(text) {
+		return fmt.Errorf("expected `%s` at %d", text, fe.peek().pos)
+	}
+	return nil
+}
+
+// The parse functions return the node and, for variables, their name.
+
+func (fe *filterExpression) parseOr() (filterNode, string, error) {
+	left, _, err := fe.parseAnd()
+	for err == nil && fe.accept("||") {
+		var right filterNode
+		if right, _, err = fe.parseAnd(); err == nil {
+			left = logical(left, right, true)
+		}
+	}
+	return left, "", err
+}
+
+func (fe *filterExpression) parseAnd() (filterNode, string, error) {
+	left, _, err := fe.parseRelation()
+	for err == nil && fe.accept("&&") {
+		var right filterNode
+		if right, _, err = fe.parseRelation(); err == nil {
+			left = logical(left, right, false)
+		}
+	}
+	return left, "", err
+}
+
+func logical(left, right filterNode, or bool) filterNode {
+	return func(env map[string]interface{}) (interface{}, error) {
+		for _, operand := range []filterNode{left, right} {
+			value, err := operand(env)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("expected a boolean, got %s", filterType(value))
+			}
+			if b == or {
+				return b, nil
+			}
+		}
+		return !or, nil
+	}
+}
+
+func (fe *filterExpression) parseRelation() (filterNode, string, error) {
+	left, name, err := fe.parseUnary()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if !fe.accept(op) {
+			continue
+		}
+		right, _, err := fe.parseUnary()
+		if err != nil {
+			return nil, "", err
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			a, err := left(env)
+			if err != nil {
+				return nil, err
+			}
+			b, err := right(env)
+			if err != nil {
+				return nil, err
+			}
+			return compareFilterValues(op, a, b)
+		}, "", nil
+	}
+	return left, name, nil
+}
+
+func compareFilterValues(op string, a, b interface{}) (interface{}, error) {
+	switch op {
+	case "==", "!=":
+		equal, err := filterEqual(a, b)
+		return equal == (op == "=="), err
+	case "in":
+		switch container := b.(type) {
+		case []interface{}:
+			for _, item := range container {
+				if equal, err := filterEqual(item, a); err != nil || equal {
+					return equal, err
+				}
+			}
+			return false, nil
+		case http.Header:
+			if name, ok := a.(string); ok {
+				_, found := container[http.CanonicalHeaderKey(name)]
+				return found, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot check if %s is in %s", filterType(a), filterType(b))
+	}
+	var cmp int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number to %s", filterType(b))
+		}
+		if x < y {
+			cmp = -1
+		} else if x > y {
+			cmp = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string to %s", filterType(b))
+		}
+		cmp = strings.Compare(x, y)
+	default:
+		return nil, fmt.Errorf("cannot order %s", filterType(a))
+	}
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// filterEqual compares scalars, values of different types being different.
+func filterEqual(a, b interface{}) (bool, error) {
+	for _, value := range []interface{}{a, b} {
+		switch value.(type) {
+		case []interface{}, http.Header, map[string]interface{}:
+			return false, fmt.Errorf("cannot compare %s", filterType(value))
+		}
+	}
+	return a == b, nil
+}
+
+func (fe *filterExpression) parseUnary() (filterNode, string, error) {
+	for _, op := range []string{"!", "-"} {
+		if !fe.accept(op) {
+			continue
+		}
+		operand, _, err := fe.parseUnary()
+		if err != nil {
+			return nil, "", err
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			value, err := operand(env)
+			if err != nil {
+				return nil, err
+			}
+			if b, ok := value.(bool); ok && op == "!" {
+				return !b, nil
+			}
+			if f, ok := value.(float64); ok && op == "-" {
+				return -f, nil
+			}
+			return nil, fmt.Errorf("cannot apply `%s` to %s", op, filterType(value))
+		}, "", nil
+	}
+	return fe.parsePostfix()
+}
+
+func (fe *filterExpression) parsePostfix() (filterNode, string, error) {
+	node, name, err := fe.parsePrimary()
+	for err == nil {
+		switch {
+		case fe.accept("."):
+			token := fe.peek()
+			if token.kind != "ident" {
+				return nil, "", fmt.Errorf("expected a field or method name at %d", token.pos)
+			}
+			fe.i++
+			if fe.accept("(") {
+				node, err = fe.parseCall(node, token)
+				name = ""
+				continue
+			}
+			if fields, ok := filterFields[name]; ok && !fields[token.text] {
+				return nil, "", fmt.Errorf("unknown field `%s.%s` at %d", name, token.text, token.pos)
+			}
+			node, name = member(node, token.text), ""
+		case fe.accept("["):
+			var index filterNode
+			if index, _, err = fe.parseOr(); err == nil {
+				err = fe.expect("]")
+			}
+			node, name = indexed(node, index), ""
+		default:
+			return node, name, nil
+		}
+	}
+	return nil, "", err
+}
+
+func member(node filterNode, field string) filterNode {
+	return func(env map[string]interface{}) (interface{}, error) {
+		value, err := node(env)
+		if err != nil {
+			return nil, err
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s has no field `%s`", filterType(value), field)
+		}
+		return fields[field], nil
+	}
+}
+
+func indexed(node, index filterNode) filterNode {
+	return func(env map[string]interface{}) (interface{}, error) {
+		value, err := node(env)
+		if err != nil {
+			return nil, err
+		}
+		key, err := index(env)
+		if err != nil {
+			return nil, err
+		}
+		switch container := value.(type) {
+		case http.Header:
+			if name, ok := key.(string); ok {
+				return container.Get(name), nil
+			}
+		case []interface{}:
+			if i, ok := key.(float64); ok && i >= 0 && int(i) < len(container) {
+				return container[int(i)], nil
+			}
+		}
+		return nil, fmt.Errorf("cannot index %s with %s", filterType(value), filterType(key))
+	}
+}
+
+// parseCall parses the arguments of a method, regular expressions of
+// `matches` being compiled once when they are literals.
+func (fe *filterExpression) parseCall(receiver filterNode, method filterToken) (filterNode, error) {
+	args := []filterNode{}
+	var literal *regexp.Regexp
+	for !fe.accept(")") {
+		if len(args) > 0 {
+			if err := fe.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		token, start := fe.peek(), fe.i
+		arg, _, err := fe.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if method.text == "matches" && token.kind == "string" && fe.i == start+1 {
+			if literal, err = regexp.Compile(token.text); err != nil {
+				return nil, fmt.Errorf("invalid regular expression at %d: %s", token.pos, err)
+			}
+		}
+		args = append(args, arg)
+	}
+
+	arity := map[string]int{"startsWith": 1, "endsWith": 1, "contains": 1, "matches": 1, "lowerAscii": 0, "size": 0}
+	if expected, ok := arity[method.text]; !ok {
+		return nil, fmt.Errorf("unknown method `%s` at %d", method.text, method.pos)
+	} else if len(args) != expected {
+		return nil, fmt.Errorf("method `%s` at %d expects %d argument(s)", method.text, method.pos, expected)
+	}
+
+	return func(env map[string]interface{}) (interface{}, error) {
+		value, err := receiver(env)
+		if err != nil {
+			return nil, err
+		}
+		if method.text == "size" {
+			switch v := value.(type) {
+			case string:
+				return float64(len(v)), nil
+			case []interface{}:
+				return float64(len(v)), nil
+			case http.Header:
+				return float64(len(v)), nil
+			}
+			return nil, fmt.Errorf("%s has no size", filterType(value))
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("method `%s` expects a string, got %s", method.text, filterType(value))
+		}
+		if method.text == "lowerAscii" {
+			return strings.ToLower(s), nil
+		}
+		argValue, err := args[0](env)
+		if err != nil {
+			return nil, err
+		}
+		arg, ok := argValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("method `%s` expects a string argument, got %s", method.text, filterType(argValue))
+		}
+		switch method.text {
+		case "startsWith":
+			return strings.HasPrefix(s, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(s, arg), nil
+		case "contains":
+			return strings.Contains(s, arg), nil
+		}
+		regex := literal
+		if regex == nil {
+			if regex, err = regexp.Compile(arg); err != nil {
+				return nil, err
+			}
+		}
+		return regex.MatchString(s), nil
+	}, nil
+}
+
+func (fe *filterExpression) parsePrimary() (filterNode, string, error) {
+	token := fe.peek()
+	fe.i++
+	switch token.kind {
+	case "string":
+		return constant(token.text), "", nil
+	case "number":
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid number `%s` at %d", token.text, token.pos)
+		}
+		return constant(f), "", nil
+	case "ident":
+		switch token.text {
+		case "true", "false":
+			return constant(token.text == "true"), "", nil
+		case "null":
+			return constant(nil), "", nil
+		}
+		if _, ok := filterFields[token.text]; !ok {
+			return nil, "", fmt.Errorf("unknown variable `%s` at %d, expected `request` or `response`", token.text, token.pos)
+		}
+		if token.text == "response" {
+			fe.usesResponse = true
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			return env[token.text], nil
+		}, token.text, nil
+	case "op":
+		switch token.text {
+		case "(":
+			node, _, err := fe.parseOr()
+			if err == nil {
+				err = fe.expect(")")
+			}
+			return node, "", err
+		case "[":
+			items := []filterNode{}
+			for !fe.accept("]") {
+				if len(items) > 0 {
+					if err := fe.expect(","); err != nil {
+						return nil, "", err
+					}
+				}
+				item, _, err := fe.parseOr()
+				if err != nil {
+					return nil, "", err
+				}
+				items = append(items, item)
+			}
+			return func(env map[string]interface{}) (interface{}, error) {
+				list := []interface{}{}
+				for _, item := range items {
+					value, err := item(env)
+					if err != nil {
+						return nil, err
+					}
+					list = append(list, value)
+				}
+				return list, nil
+			}, "", nil
+		}
+	}
+	if token.kind == "eof" {
+		return nil, "", fmt.Errorf("unexpected end of expression")
+	}
+	return nil, "", fmt.Errorf("unexpected `%s` at %d", token.text, token.pos)
+}
+
+func constant(value interface{}) filterNode {
+	return func(map[string]interface{}) (interface{}, error) {
+		return value, nil
+	}
+}
+
+func filterType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case http.Header:
+		return "headers"
+	}
+	return "map"
+}
+
+// filterEnv returns the variables of an exchange, the response being
+// omitted (status 0, no headers) when unknown.
+func filterEnv(r *http.Request, status int, headers http.Header) map[string]interface{} {
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return map[string]interface{}{
+		"request": map[string]interface{}{
+			"method":  r.Method,
+			"path":    r.URL.Path,
+			"host":    r.Host,
+			"uri":     r.RequestURI,
+			"query":   r.URL.RawQuery,
+			"remote":  r.RemoteAddr,
+			"headers": r.Header,
+		},
+		"response": map[string]interface{}{
+			"status":  float64(status),
+			"headers": headers,
+		},
+	}
+}
+
+// match evaluates the expression, exchanges failing to be evaluated being
+// recorded.
+func (fe *filterExpression) match(ghr goHRec, req string, env map[string]interface{}) bool {
+	value, err := fe.root(env)
+	if err == nil {
+		if b, ok := value.(bool); ok {
+			return b
+		}
+		err = fmt.Errorf("expected a boolean, got %s", filterType(value))
+	}
+	ghr.log(slog.LevelWarn, "Error while evaluating --filter, recording anyway", "request", req, "error", err)
+	return true
+}
+
+// needsResponse tells if the expression can only be evaluated once the
+// response is known.
+func (fe *filterExpression) needsResponse() bool {
+	return fe != nil && fe.usesResponse
+}
+
+// isFilteredOut tells if an exchange, with the response status and headers
+// if known, doesn't match --filter.
+func (ghr goHRec) isFilteredOut(r *http.Request, req string, status int, headers http.Header) bool {
+	if ghr.filter == nil {
+		return false
+	}
+	return ghr.skipFiltered(r, req, filterEnv(r, status, headers))
+}
+
+func (ghr goHRec) skipFiltered(r *http.Request, req string, env map[string]interface{}) bool {
+	if ghr.filter.match(ghr, req, env) {
+		return false
+	}
+	ghr.log(slog.LevelDebug, "Skipped: doesn't match --filter.", "request", req)
+	ghr.recordSkip(r, "filtered")
+	return true
+}
+
+type filterDecisionKey struct{}
+
+// filterDecision carries the variables of a proxied request until its
+// response is known, when --filter depends on it, and whether the exchange
+// is then filtered out.
+type filterDecision struct {
+	env       map[string]interface{}
+	evaluated bool
+	skipped   bool
+}
+
+func filterDecisionOf(r *http.Request) *filterDecision {
+	if r == nil {
+		return nil
+	}
+	decision, _ := r.Context().Value(filterDecisionKey{}).(*filterDecision)
+	return decision
+}
+
+// deferFilter attaches a decision to a proxied request whose filtering
+// depends on its response.
+func (ghr goHRec) deferFilter(r *http.Request) (*http.Request, *filterDecision) {
+	if !ghr.filter.needsResponse() {
+		return r, nil
+	}
+	decision := &filterDecision{env: filterEnv(r, 0, nil)}
+	return r.WithContext(context.WithValue(r.Context(), filterDecisionKey{}, decision)), decision
+}
+
+// decide evaluates the expression once the response, if any, is known.
+func (fd *filterDecision) decide(ghr goHRec, r *http.Request, req string, resp *http.Response) bool {
+	if fd.evaluated {
+		return fd.skipped
+	}
+	fd.evaluated = true
+	if resp != nil {
+		fd.env["response"] = map[string]interface{}{"status": float64(resp.StatusCode), "headers": resp.Header}
+	}
+	fd.skipped = ghr.skipFiltered(r, req, fd.env)
+	return fd.skipped
+}
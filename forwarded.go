@@ -0,0 +1,120 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the networks whose forwarding headers are trusted to
+// tell the real client address.
+type trustedProxies []*net.IPNet
+
+func makeTrustedProxies(cidrs string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		network := cidr
+		if !strings.Contains(network, "/") {
+			if strings.Contains(network, ":") {
+				network += "/128"
+			} else {
+				network += "/32"
+			}
+		}
+		_, trusted, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --trust-forwarded-headers `%s`, expected a network like `10.0.0.0/8` or an IP address.", cidr)
+		}
+		proxies = append(proxies, trusted)
+	}
+	return proxies, nil
+}
+
+func (tp trustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the addresses of the `for` parameters of a Forwarded
+// header, from the client to the closest proxy.
+func forwardedFor(values []string) []string {
+	var addrs []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				split := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(split) != 2 || !strings.EqualFold(split[0], "for") {
+					continue
+				}
+				addr := strings.Trim(split[1], `"`)
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					addr = host
+				}
+				addrs = append(addrs, strings.Trim(addr, "[]"))
+			}
+		}
+	}
+	return addrs
+}
+
+// clientAddr returns the address of the client of a request, read from the
+// forwarding headers when the socket peer is a trusted proxy. The closest
+// untrusted address of the chain is the client, as any before it could have
+// been forged.
+func (tp trustedProxies) clientAddr(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if len(tp) == 0 || !tp.trusts(peer) {
+		return r.RemoteAddr
+	}
+
+	chain := forwardedFor(r.Header.Values("Forwarded"))
+	if len(chain) == 0 {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(value, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					chain = append(chain, addr)
+				}
+			}
+		}
+	}
+	if len(chain) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+		return r.RemoteAddr
+	}
+	for i := len(chain) - 1; i > 0; i-- {
+		if !tp.trusts(chain[i]) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
+
+// peerAddr returns the socket peer of a request when it is not the recorded
+// RemoteAddr.
+func (ghr goHRec) peerAddr(r *http.Request) string {
+	if ghr.trustedProxies.clientAddr(r) == r.RemoteAddr {
+		return ""
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var fuzzStrings = []string{
+	"",
+	" ",
+	"-1",
+	"0",
+	"999999999999999999999999",
+	"null",
+	"true",
+	"%00",
+	"%s%s%s%n",
+	"' OR '1'='1",
+	"\"; DROP TABLE users; --",
+	"<script>alert(1)</script>",
+	"../../../../etc/passwd",
+	"${jndi:ldap://localhost/a}",
+	"{{7*7}}",
+	"\u202e\u0000\uffff",
+	"😀😀😀",
+}
+
+var fuzzJSONValues = []interface{}{
+	nil, "", -1, 0, 1e308, true, false, []interface{}{}, map[string]interface{}{}, json.Number("99999999999999999999"),
+}
+
+var fuzzContentTypes = []string{
+	"application/json", "application/xml", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data; boundary=x", "application/octet-stream", "",
+}
+
+// fuzzMaxBodySize bounds the bodies extended by fuzzBodySize, so that large
+// seeds do not exhaust the memory.
+const fuzzMaxBodySize = 1 << 20
+
+type fuzzMutator func(r *rand.Rand, record *redoRecord) string
+
+func fuzzString(r *rand.Rand) string {
+	if r.Intn(4) == 0 {
+		return strings.Repeat("A", 1<<uint(8+r.Intn(10)))
+	}
+	return fuzzStrings[r.Intn(len(fuzzStrings))]
+}
+
+func fuzzHeaderValue(r *rand.Rand, record *redoRecord) string {
+	if len(record.Headers) == 0 {
+		record.Headers = append(record.Headers, "X-Fuzz: "+fuzzString(r))
+		return "header: added X-Fuzz"
+	}
+	headers := append([]string{}, record.Headers...)
+	i := r.Intn(len(headers))
+	name := strings.SplitN(headers[i], ": ", 2)[0]
+	headers[i] = name + ": " + fuzzString(r)
+	record.Headers = headers
+	return "header: " + name
+}
+
+func fuzzJSONField(r *rand.Rand, record *redoRecord) string {
+	doc, ok := decodeJSON(record.Body)
+	if !ok {
+		return fuzzBodySize(r, record)
+	}
+	leaves := []jsonPath{}
+	var walk func(node interface{}, segments []string)
+	walk = func(node interface{}, segments []string) {
+		switch value := node.(type) {
+		case map[string]interface{}:
+			keys := []string{}
+			for key := range value {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				walk(value[key], append(append([]string{}, segments...), key))
+			}
+		case []interface{}:
+			for i, child := range value {
+				walk(child, append(append([]string{}, segments...), fmt.Sprint(i)))
+			}
+		default:
+			leaves = append(leaves, jsonPath{raw: "$." + strings.Join(segments, "."), segments: segments})
+		}
+	}
+	walk(doc, []string{})
+	if len(leaves) == 0 {
+		return fuzzBodySize(r, record)
+	}
+	leaf := leaves[r.Intn(len(leaves))]
+	doc = leaf.replace(doc, func(interface{}) interface{} {
+		if r.Intn(2) == 0 {
+			return fuzzString(r)
+		}
+		return fuzzJSONValues[r.Intn(len(fuzzJSONValues))]
+	})
+	record.Body = encodeJSON(doc)
+	return "json: " + leaf.raw
+}
+
+func fuzzBodySize(r *rand.Rand, record *redoRecord) string {
+	switch r.Intn(3) {
+	case 0:
+		if len(record.Body) > 0 {
+			record.Body = record.Body[:r.Intn(len(record.Body))]
+			return "size: truncated body"
+		}
+		fallthrough
+	case 1:
+		size := len(record.Body) + (len(record.Body)+1)*(1+r.Intn(1000))
+		if size > fuzzMaxBodySize {
+			size = fuzzMaxBodySize
+		}
+		if size <= len(record.Body) {
+			size = len(record.Body) + 1
+		}
+		unit := record.Body + "A"
+		record.Body = (record.Body + strings.Repeat(unit, (size-len(record.Body))/len(unit)+1))[:size]
+		return "size: extended body"
+	}
+	record.Body = ""
+	return "size: emptied body"
+}
+
+func fuzzEncoding(r *rand.Rand, record *redoRecord) string {
+	headers := []string{}
+	for _, header := range record.Headers {
+		lower := strings.ToLower(header)
+		if !strings.HasPrefix(lower, "content-type:") && !strings.HasPrefix(lower, "content-encoding:") {
+			headers = append(headers, header)
+		}
+	}
+	switch r.Intn(3) {
+	case 0:
+		contentType := fuzzContentTypes[r.Intn(len(fuzzContentTypes))]
+		record.Headers = append(headers, "Content-Type: "+contentType)
+		return "encoding: content-type " + contentType
+	case 1:
+		record.Headers = append(headers, "Content-Encoding: gzip")
+		return "encoding: bogus gzip"
+	}
+	record.URI = strings.Replace(url.PathEscape(record.URI), "%2F", "/", -1)
+	record.Headers = append(headers, "Content-Type: text/plain; charset=utf-7")
+	return "encoding: escaped uri and utf-7 charset"
+}
+
+var fuzzMutators = []fuzzMutator{fuzzHeaderValue, fuzzJSONField, fuzzBodySize, fuzzEncoding}
+
+type fuzzResult struct {
+	Seed, Mutation string
+	Method, URI    string
+	Headers        []string
+	Body           string
+	StatusCode     int
+	Error          string `json:",omitempty"`
+	Timeout        bool
+	Flagged        bool
+	Duration       time.Duration
+
+	ResponseHeaders       []string `json:",omitempty"`
+	ResponseBody          string   `json:",omitempty"`
+	ResponseBodyTruncated bool     `json:",omitempty"`
+}
+
+// readResponse stores in the result the headers of a target response and its
+// body, truncated to maxBodySize bytes unless negative.
+func (result *fuzzResult) readResponse(resp *http.Response, maxBodySize int64) error {
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	result.ResponseHeaders = dumpValues(resp.Header)
+	var bodyReader io.Reader = resp.Body
+	if maxBodySize >= 0 {
+		bodyReader = io.LimitReader(resp.Body, maxBodySize+1)
+	}
+	body, err := ioutil.ReadAll(bodyReader)
+	if maxBodySize >= 0 && int64(len(body)) > maxBodySize {
+		body = body[:maxBodySize]
+		result.ResponseBodyTruncated = true
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	result.ResponseBody = string(body)
+	return err
+}
+
+func fuzz() {
+	fuzzer := flag.NewFlagSet("fuzz", flag.PanicOnError)
+	seedDir := fuzzer.String("seed-dir", "", "Directory of request records used as fuzzing seeds.")
+	targetURL := fuzzer.String("target-url", "", "Base URL of the target the mutated requests are sent to.")
+	iterations := fuzzer.Int("iterations", 10, "Number of mutations sent for each seed.")
+	seed := fuzzer.Int64("seed", 0, "Seed of the random generator, `0` to use the current time.")
+	timeout := fuzzer.Duration("timeout", 10*time.Second, "Timeout of each mutated request.")
+	report := fuzzer.String("report", "fuzz-report.json", "File where each mutated request and its outcome are appended as JSON lines.")
+	maxResponseSize := fuzzer.Int64("max-response-size", 64<<10, "Maximum size in bytes of the response bodies stored in the report, `-1` to disallow limit.")
+	fuzzer.Parse(os.Args[2:])
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	log.Printf("  seed-dir: %s", *seedDir)
+	log.Printf("  target-url: %s", *targetURL)
+	log.Printf("  iterations: %d", *iterations)
+	log.Printf("  seed: %d", *seed)
+	log.Printf("  timeout: %s", *timeout)
+	log.Printf("  report: %s", *report)
+	log.Printf("  max-response-size: %d", *maxResponseSize)
+
+	if *seedDir == "" || *targetURL == "" {
+		log.Fatal("--seed-dir and --target-url are required.")
+	}
+
+	files, err := loadRedoDir(*seedDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.OpenFile(*report, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Error while opening %s: %s", *report, err)
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+
+	r := rand.New(rand.NewSource(*seed))
+	rd := redoer{client: http.Client{Timeout: *timeout}}
+	sent, flagged := 0, 0
+	for _, file := range files {
+		for i := 0; i < *iterations; i++ {
+			record := file.record
+			mutation := fuzzMutators[r.Intn(len(fuzzMutators))](r, &record)
+			result := fuzzResult{
+				Seed:     file.name,
+				Mutation: mutation,
+				Method:   record.Method,
+				URI:      record.URI,
+				Headers:  record.Headers,
+				Body:     record.Body,
+			}
+
+			req, err := rd.prepare(record, *targetURL)
+			if err != nil {
+				log.Printf("%s (%s)", err, file.name)
+				continue
+			}
+			start := time.Now()
+			resp, err := rd.client.Do(req)
+			if err == nil {
+				err = result.readResponse(resp, *maxResponseSize)
+			}
+			result.Duration = time.Since(start)
+			if err != nil {
+				result.Error = err.Error()
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					result.Timeout = true
+				}
+			}
+			result.Flagged = result.Timeout || result.StatusCode >= 500
+			sent++
+
+			if result.Flagged {
+				flagged++
+				log.Printf("Flagged: %s %s [%d] %s %s", result.Method, result.URI, result.StatusCode, result.Mutation, result.Error)
+			}
+			if err := encoder.Encode(result); err != nil {
+				log.Printf("Error while writing report: %s", err)
+			}
+		}
+	}
+	log.Printf("Fuzzed %d seed(s) with %d request(s): %d flagged", len(files), sent, flagged)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFuzzBodySizeIsCapped(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	seed := strings.Repeat("x", fuzzMaxBodySize/2)
+	for i := 0; i < 100; i++ {
+		record := redoRecord{Body: seed}
+		fuzzBodySize(r, &record)
+		if len(record.Body) > fuzzMaxBodySize {
+			t.Fatalf("expected a body of at most %d bytes, got %d", fuzzMaxBodySize, len(record.Body))
+		}
+	}
+}
+
+func TestFuzzResultStoresResponse(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace", "trace")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("stack trace"))
+	}))
+	defer target.Close()
+	resp, err := http.Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result fuzzResult
+	if err := result.readResponse(resp, 5); err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, result.StatusCode)
+	}
+	if !strings.Contains(strings.Join(result.ResponseHeaders, "\n"), "X-Trace: trace") {
+		t.Fatalf("expected the X-Trace header, got %v", result.ResponseHeaders)
+	}
+	if result.ResponseBody != "stack" || !result.ResponseBodyTruncated {
+		t.Fatalf("expected a truncated body, got %q", result.ResponseBody)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+// Package gohrectest starts in-process gohrec recorders and stubs for Go
+// tests: a recorder proxies requests to a target and writes request and
+// response records like `gohrec record --proxy`, a stub replays the
+// responses of such records like `gohrec serve`.
+package gohrectest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// RecorderOptions configures a recorder.
+type RecorderOptions struct {
+	// Target is the URL requests are forwarded to.
+	Target string
+	// Dir is the directory records are written to, a temporary directory
+	// removed with the test if empty.
+	Dir string
+}
+
+// Recorder is a recording proxy started by StartRecorder.
+type Recorder struct {
+	// URL of the recorder, like `http://127.0.0.1:1234`.
+	URL string
+	// Dir where records are written.
+	Dir string
+
+	t     testing.TB
+	mutex sync.Mutex
+	count int
+}
+
+// Stub is a server replaying records started by StartStub.
+type Stub struct {
+	// URL of the stub, like `http://127.0.0.1:1234`.
+	URL string
+
+	mutex     sync.Mutex
+	responses map[string][]record
+	served    map[string]int
+}
+
+type record struct {
+	ID                      string
+	Date, DateUTC           time.Time
+	DateUnixNano            int64
+	Protocol                string
+	Headers                 []string
+	ContentLength           int64
+	Body                    string
+	BodyEncoding            string `json:",omitempty"`
+	Trailers                []string
+	TransferEncodings       []string
+	RemoteAddr              string   `json:",omitempty"`
+	Host, Method, Path, URI string   `json:",omitempty"`
+	Query                   []string `json:",omitempty"`
+	Status                  string   `json:",omitempty"`
+	StatusCode              int      `json:",omitempty"`
+}
+
+func dumpValues(in map[string][]string) []string {
+	out := []string{}
+	for name, values := range in {
+		for _, value := range values {
+			out = append(out, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *record) setBody(content []byte) {
+	if utf8.Valid(content) {
+		r.Body = string(content)
+		return
+	}
+	r.Body = base64.StdEncoding.EncodeToString(content)
+	r.BodyEncoding = "base64"
+}
+
+func (r record) body() []byte {
+	if r.BodyEncoding == "base64" {
+		content, _ := base64.StdEncoding.DecodeString(r.Body)
+		return content
+	}
+	return []byte(r.Body)
+}
+
+// StartRecorder starts a proxy recording the requests it forwards to
+// opts.Target and their responses, stopped with the test.
+func StartRecorder(t testing.TB, opts RecorderOptions) *Recorder {
+	t.Helper()
+	target, err := url.Parse(opts.Target)
+	if err != nil || target.Host == "" {
+		t.Fatalf("gohrectest: invalid target `%s`", opts.Target)
+	}
+	rec := &Recorder{Dir: opts.Dir, t: t}
+	if rec.Dir == "" {
+		rec.Dir = t.TempDir()
+	} else if err := os.MkdirAll(rec.Dir, 0755); err != nil {
+		t.Fatalf("gohrectest: error while creating %s: %s", rec.Dir, err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = rec.saveResponse
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := rec.saveRequest(r)
+		r = r.WithContext(context.WithValue(r.Context(), idKey{}, id))
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	rec.URL = server.URL
+	return rec
+}
+
+type idKey struct{}
+
+// Count returns the number of records written.
+func (rec *Recorder) Count() int {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return rec.count
+}
+
+func (rec *Recorder) save(r record, kind string) {
+	content, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		rec.t.Errorf("gohrectest: error while serializing record: %s", err)
+		return
+	}
+	name := fmt.Sprintf("%s.%s.%s.json", r.Date.Format("15-04-05_000000000"), r.ID, kind)
+	if err := ioutil.WriteFile(filepath.Join(rec.Dir, name), content, 0644); err != nil {
+		rec.t.Errorf("gohrectest: error while writing record: %s", err)
+		return
+	}
+	rec.mutex.Lock()
+	rec.count++
+	rec.mutex.Unlock()
+}
+
+func (rec *Recorder) saveRequest(r *http.Request) string {
+	now := time.Now()
+	id := make([]byte, 12)
+	rand.Read(id)
+	body := []byte{}
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	req := record{
+		ID:                hex.EncodeToString(id),
+		Date:              now,
+		DateUTC:           now.UTC(),
+		DateUnixNano:      now.UnixNano(),
+		Protocol:          r.Proto,
+		Headers:           dumpValues(r.Header),
+		ContentLength:     r.ContentLength,
+		Trailers:          dumpValues(r.Trailer),
+		TransferEncodings: r.TransferEncoding,
+		RemoteAddr:        r.RemoteAddr,
+		Host:              r.Host,
+		Method:            r.Method,
+		Path:              r.URL.Path,
+		Query:             dumpValues(r.URL.Query()),
+		URI:               r.URL.RequestURI(),
+	}
+	req.setBody(body)
+	rec.save(req, "request")
+	return req.ID
+}
+
+func (rec *Recorder) saveResponse(resp *http.Response) error {
+	now := time.Now()
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	id, _ := resp.Request.Context().Value(idKey{}).(string)
+	res := record{
+		ID:                id,
+		Date:              now,
+		DateUTC:           now.UTC(),
+		DateUnixNano:      now.UnixNano(),
+		Protocol:          resp.Proto,
+		Headers:           dumpValues(resp.Header),
+		ContentLength:     resp.ContentLength,
+		Trailers:          dumpValues(resp.Trailer),
+		TransferEncodings: resp.TransferEncoding,
+		Status:            resp.Status,
+		StatusCode:        resp.StatusCode,
+	}
+	res.setBody(body)
+	rec.save(res, "response")
+	return nil
+}
+
+func readRecord(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil || len(content) < 2 || content[0] != 0x1f || content[1] != 0x8b {
+		return content, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func kindOf(file string) string {
+	for _, kind := range []string{"request", "response", "pair"} {
+		suffix := "." + kind + ".json"
+		if strings.HasSuffix(file, suffix) || strings.HasSuffix(file, suffix+".gz") {
+			return kind
+		}
+	}
+	return ""
+}
+
+// StartStub starts a server answering requests with the recorded responses
+// of the records of cassetteDir, matched by method and URI in their recorded
+// order, the last one being repeated. Unmatched requests fail the test.
+func StartStub(t testing.TB, cassetteDir string) *Stub {
+	t.Helper()
+	requests, responses := map[string]record{}, map[string]record{}
+	err := filepath.Walk(cassetteDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		kind := kindOf(path)
+		if kind == "" {
+			return nil
+		}
+		content, err := readRecord(path)
+		if err != nil {
+			return err
+		}
+		if kind == "pair" {
+			var pair struct{ Request, Response *record }
+			if err := json.Unmarshal(content, &pair); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			if pair.Request != nil && pair.Response != nil {
+				requests[pair.Request.ID], responses[pair.Request.ID] = *pair.Request, *pair.Response
+			}
+			return nil
+		}
+		var r record
+		if err := json.Unmarshal(content, &r); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if kind == "request" {
+			requests[r.ID] = r
+		} else {
+			responses[r.ID] = r
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("gohrectest: error while loading %s: %s", cassetteDir, err)
+	}
+
+	ids := []string{}
+	for id := range requests {
+		if _, ok := responses[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return requests[ids[i]].DateUnixNano < requests[ids[j]].DateUnixNano
+	})
+	stub := &Stub{responses: map[string][]record{}, served: map[string]int{}}
+	for _, id := range ids {
+		key := requests[id].Method + " " + requests[id].URI
+		stub.responses[key] = append(stub.responses[key], responses[id])
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := stub.next(r.Method + " " + r.URL.RequestURI())
+		if !ok {
+			t.Errorf("gohrectest: no recorded response to %s %s", r.Method, r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "No recorded response.")
+			return
+		}
+		for _, header := range response.Headers {
+			split := strings.SplitN(header, ": ", 2)
+			if len(split) != 2 || split[0] == "Content-Length" || split[0] == "Transfer-Encoding" || split[0] == "Connection" {
+				continue
+			}
+			w.Header().Add(split[0], split[1])
+		}
+		w.WriteHeader(response.StatusCode)
+		w.Write(response.body())
+	}))
+	t.Cleanup(server.Close)
+	stub.URL = server.URL
+	return stub
+}
+
+func (s *Stub) next(key string) (record, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	responses := s.responses[key]
+	if len(responses) == 0 {
+		return record{}, false
+	}
+	i := s.served[key]
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	s.served[key] = i + 1
+	return responses[i], true
+}
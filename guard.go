@@ -0,0 +1,143 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the replay audit log.
+type auditEntry struct {
+	Date       time.Time
+	User, Host string
+	Method     string
+	URL        string
+	StatusCode int    `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	Refused    bool   `json:",omitempty"`
+}
+
+// replayGuard refuses to send redone requests to hosts which are not on the
+// safelist, and appends what was sent where, and by whom, to the audit log.
+type replayGuard struct {
+	safelist   []string
+	audit      *log.Logger
+	mutex      sync.Mutex
+	user, host string
+}
+
+// makeReplayGuard returns the guard of a comma-separated safelist of hosts
+// (like `localhost,*.staging.example.com:8443`) and of an audit log file, nil
+// if there are none.
+func makeReplayGuard(safelist, auditLog string) (*replayGuard, error) {
+	if safelist == "" && auditLog == "" {
+		return nil, nil
+	}
+	g := &replayGuard{}
+	for _, host := range strings.Split(safelist, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			if _, err := path.Match(host, ""); err != nil {
+				return nil, fmt.Errorf("Invalid --target-safelist host `%s`: %s", host, err)
+			}
+			g.safelist = append(g.safelist, host)
+		}
+	}
+	if auditLog != "" {
+		f, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Error while opening %s: %s", auditLog, err)
+		}
+		g.audit = log.New(f, "", 0)
+		g.user = os.Getenv("USER")
+		if u, err := user.Current(); err == nil {
+			g.user = u.Username
+		}
+		g.host, _ = os.Hostname()
+	}
+	return g, nil
+}
+
+// allowed tells if the host of a URL is on the safelist, a safelisted host
+// without port allowing any port.
+func (g *replayGuard) allowed(host string) bool {
+	if g.safelist == nil {
+		return true
+	}
+	host = strings.ToLower(host)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, pattern := range g.safelist {
+		name := hostname
+		if _, _, err := net.SplitHostPort(pattern); err == nil {
+			name = host
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *replayGuard) log(entry auditEntry) {
+	if g.audit == nil {
+		return
+	}
+	entry.Date, entry.User, entry.Host = time.Now().UTC(), g.user, g.host
+	content, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error while serializing audit entry: %s", err)
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.audit.Print(string(content))
+}
+
+// wrap returns a transport guarding next.
+func (g *replayGuard) wrap(next http.RoundTripper) http.RoundTripper {
+	if g == nil {
+		return next
+	}
+	return guardedTransport{guard: g, next: next}
+}
+
+type guardedTransport struct {
+	guard *replayGuard
+	next  http.RoundTripper
+}
+
+func (gt guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := auditEntry{Method: req.Method, URL: req.URL.String()}
+	if !gt.guard.allowed(req.URL.Host) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		entry.Refused = true
+		entry.Error = fmt.Sprintf("Host `%s` is not on the --target-safelist.", req.URL.Host)
+		gt.guard.log(entry)
+		return nil, errors.New(entry.Error)
+	}
+	resp, err := gt.next.RoundTrip(req)
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.StatusCode = resp.StatusCode
+	}
+	gt.guard.log(entry)
+	return resp, err
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerEdits removes, replaces and adds headers of the loaded records.
+type headerEdits struct {
+	remove map[string]bool
+	set    []string
+	add    []string
+}
+
+func makeHeaderEdits(set, remove, add []string) (*headerEdits, error) {
+	if len(set) == 0 && len(remove) == 0 && len(add) == 0 {
+		return nil, nil
+	}
+	he := &headerEdits{remove: map[string]bool{}}
+	for _, name := range remove {
+		he.remove[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	for _, specs := range []struct {
+		flag    string
+		headers []string
+		to      *[]string
+	}{{"--set-header", set, &he.set}, {"--add-header", add, &he.add}} {
+		for _, header := range specs.headers {
+			split := strings.SplitN(header, ":", 2)
+			name := http.CanonicalHeaderKey(strings.TrimSpace(split[0]))
+			if len(split) != 2 || name == "" {
+				return nil, fmt.Errorf("Invalid %s `%s`, expected `Name: value`.", specs.flag, header)
+			}
+			*specs.to = append(*specs.to, name+": "+strings.TrimSpace(split[1]))
+			if specs.flag == "--set-header" {
+				he.remove[name] = true
+			}
+		}
+	}
+	return he, nil
+}
+
+// apply removes the headers of --remove-header and --set-header, then adds
+// the ones of --set-header and --add-header.
+func (he *headerEdits) apply(record *redoRecord) {
+	if he == nil {
+		return
+	}
+	headers := []string{}
+	for _, header := range record.Headers {
+		if !he.remove[http.CanonicalHeaderKey(strings.SplitN(header, ":", 2)[0])] {
+			headers = append(headers, header)
+		}
+	}
+	headers = append(headers, he.set...)
+	record.Headers = append(headers, he.add...)
+}
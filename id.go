@@ -0,0 +1,68 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+var idFormats = map[string]bool{"legacy": true, "uuid7": true, "ulid": true}
+
+func checkIDFormat(format string) error {
+	if !idFormats[format] {
+		return fmt.Errorf("Unsupported ID format `%s`, expected `legacy`, `uuid7` or `ulid`.", format)
+	}
+	return nil
+}
+
+// makeRequestID returns the ID of a request in the format of --id-format.
+func (ghr goHRec) makeRequestID(req string, received time.Time) string {
+	switch ghr.idFormat {
+	case "uuid7":
+		return makeUUIDv7(received)
+	case "ulid":
+		return makeULID(received)
+	default:
+		return makeRequestID(req, received)
+	}
+}
+
+// makeUUIDv7 returns a RFC 9562 version 7 UUID, sortable by time.
+func makeUUIDv7(t time.Time) string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid[6:])
+	ms := make([]byte, 8)
+	binary.BigEndian.PutUint64(ms, uint64(t.UnixNano()/int64(time.Millisecond)))
+	copy(uuid[0:6], ms[2:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x70
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// makeULID returns a ULID: a 48 bits timestamp in milliseconds followed by 80
+// random bits, as 26 Crockford's base32 characters.
+func makeULID(t time.Time) string {
+	id := make([]byte, 16)
+	ms := make([]byte, 8)
+	binary.BigEndian.PutUint64(ms, uint64(t.UnixNano()/int64(time.Millisecond)))
+	copy(id[0:6], ms[2:])
+	rand.Read(id[6:])
+
+	// 128 bits are encoded from the most significant ones, the first
+	// character holding only 3 bits.
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
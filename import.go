@@ -0,0 +1,205 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	curlTraceSection = regexp.MustCompile(`^(=>|<=|==) (\w+ \w+|Info:)`)
+	curlTraceData    = regexp.MustCompile(`^[0-9a-f]{4}: ?(.*)$`)
+	curlTraceHex     = regexp.MustCompile(`^((?:[0-9a-f]{2} ){1,16})`)
+)
+
+// parseRawHTTP splits an editor-style raw HTTP file (requests separated by
+// `###`, request line possibly without protocol) into raw HTTP/1.1 requests.
+func parseRawHTTP(content []byte) [][]byte {
+	requests := [][]byte{}
+	text := strings.Replace(string(content), "\r\n", "\n", -1)
+	for _, block := range regexp.MustCompile(`(?m)^###.*$`).Split(text, -1) {
+		lines := strings.Split(block, "\n")
+		start := 0
+		for start < len(lines) {
+			line := strings.TrimSpace(lines[start])
+			if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "//") {
+				break
+			}
+			start++
+		}
+		if start == len(lines) {
+			continue
+		}
+
+		requestLine := strings.Fields(lines[start])
+		if len(requestLine) == 1 {
+			requestLine = []string{http.MethodGet, requestLine[0]}
+		}
+		if len(requestLine) == 2 {
+			requestLine = append(requestLine, "HTTP/1.1")
+		}
+
+		headers := []string{}
+		end := start + 1
+		for ; end < len(lines) && strings.TrimSpace(lines[end]) != ""; end++ {
+			headers = append(headers, strings.TrimSpace(lines[end]))
+		}
+		body := ""
+		if end < len(lines) {
+			body = strings.TrimRight(strings.Join(lines[end+1:], "\n"), "\n")
+		}
+
+		var raw bytes.Buffer
+		fmt.Fprintf(&raw, "%s\r\n", strings.Join(requestLine, " "))
+		hasLength := false
+		for _, header := range headers {
+			lower := strings.ToLower(header)
+			if strings.HasPrefix(lower, "content-length:") || strings.HasPrefix(lower, "transfer-encoding:") {
+				hasLength = true
+			}
+			fmt.Fprintf(&raw, "%s\r\n", header)
+		}
+		if !hasLength && body != "" {
+			fmt.Fprintf(&raw, "Content-Length: %d\r\n", len(body))
+		}
+		fmt.Fprintf(&raw, "\r\n%s", body)
+		requests = append(requests, raw.Bytes())
+	}
+	return requests
+}
+
+// parseCurlTrace extracts the sent requests from the output of `curl --trace`
+// or `curl --trace-ascii`.
+func parseCurlTrace(content []byte) [][]byte {
+	requests := [][]byte{}
+	var current *bytes.Buffer
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := curlTraceSection.FindStringSubmatch(line); match != nil {
+			previous := section
+			section = match[2]
+			if section == "Send header" && (current == nil || previous == "Send data" || current.Len() > 0) {
+				if current != nil && current.Len() > 0 {
+					requests = append(requests, current.Bytes())
+				}
+				current = &bytes.Buffer{}
+			}
+			continue
+		}
+		if current == nil || (section != "Send header" && section != "Send data") {
+			continue
+		}
+		match := curlTraceData.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if hexa := curlTraceHex.FindString(match[1]); hexa != "" {
+			if data, err := hex.DecodeString(strings.Replace(hexa, " ", "", -1)); err == nil {
+				current.Write(data)
+				continue
+			}
+		}
+		current.WriteString(match[1])
+		if section == "Send header" {
+			current.WriteString("\r\n")
+		}
+	}
+	if current != nil && current.Len() > 0 {
+		requests = append(requests, current.Bytes())
+	}
+	return requests
+}
+
+func (ghr goHRec) importRequest(raw []byte, source string) error {
+	r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return fmt.Errorf("Error while parsing request from %s: %s", source, err)
+	}
+	if r.URL.IsAbs() {
+		if r.Host == "" {
+			r.Host = r.URL.Host
+		}
+		r.RequestURI = r.URL.RequestURI()
+	}
+
+	rt := recordingTime{requestReceived: time.Now()}
+	req := makeRequestName(r)
+	record := ghr.prepareRequestRecord(r, rt)
+	ghr.saveRequest(req, record, rt, r.Body)
+	return nil
+}
+
+func importRecords() {
+	importer := flag.NewFlagSet("import", flag.PanicOnError)
+	raw := importer.String("raw", "", "Raw HTTP file (like `request.http`) to import, requests being separated by `###`.")
+	curlTrace := importer.String("curl-trace", "", "Output of `curl --trace` or `curl --trace-ascii` to import.")
+	dateFormat := importer.String("date-format", defaultDateFormat, "Go format of the date used in record filenames, required subfolders are created automatically.")
+	compress := importer.String("compress", "", "If set, compress record files with this format: `gzip`.")
+	idFormat := importer.String("id-format", "legacy", "Format of record IDs: `legacy`, `uuid7` or `ulid`.")
+	importer.Parse(os.Args[2:])
+
+	log.Printf("  raw: %s", *raw)
+	log.Printf("  curl-trace: %s", *curlTrace)
+	log.Printf("  date-format: %s", *dateFormat)
+	log.Printf("  compress: %s", *compress)
+	log.Printf("  id-format: %s", *idFormat)
+
+	if err := checkCompression(*compress); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkIDFormat(*idFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	gohrec := goHRec{
+		dateFormat:  *dateFormat,
+		maxBodySize: -1,
+		compress:    *compress,
+		idFormat:    *idFormat,
+		logger:      slog.New(slog.NewTextHandler(os.Stderr, nil)).With("component", "import"),
+	}
+
+	sources := []struct {
+		file  string
+		parse func([]byte) [][]byte
+	}{
+		{*raw, parseRawHTTP},
+		{*curlTrace, parseCurlTrace},
+	}
+	imported := 0
+	for _, source := range sources {
+		if source.file == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(source.file)
+		if err != nil {
+			log.Fatalf("Error while reading %s: %s", source.file, err)
+		}
+		for i, request := range source.parse(content) {
+			if err := gohrec.importRequest(request, source.file+"#"+strconv.Itoa(i+1)); err != nil {
+				log.Print(err)
+				continue
+			}
+			imported++
+		}
+	}
+	log.Printf("Imported %d request(s).", imported)
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// secondaryIndexes are the `index.<key>.log` files of `value\tid\tfile` lines,
+// sorted by value, built by `gohrec index`.
+var secondaryIndexes = []string{"path", "status", "label"}
+
+// indexEntry is a line of the index, with the values of the secondary
+// indexes of its record.
+type indexEntry struct {
+	id, file, req string
+	date          int64
+	values        map[string][]string
+}
+
+func (ie indexEntry) line() string {
+	return ie.id + "\t" + ie.file + "\t" + ie.req
+}
+
+// indexedRecord holds the fields of request, response, pair and skip records
+// needed to index them.
+type indexedRecord struct {
+	ID                             string
+	DateUnixNano                   int64
+	RemoteAddr, Host, Method, Path string
+	URI                            string
+	StatusCode                     int
+	Request                        *indexedRecord
+	Response                       *indexedRecord
+}
+
+// scanIndexEntries reads the record files of dir, and the labels of their
+// annotations.
+func scanIndexEntries(dir string) ([]indexEntry, error) {
+	entries := []indexEntry{}
+	requests := map[string]string{}
+	labels := map[string][]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, _ := filepath.Rel(dir, path)
+		if isRecordFile(path, "annotations") {
+			annotations, err := loadAnnotations(path)
+			if err != nil {
+				log.Printf("Error while reading %s: %s", path, err)
+				return nil
+			}
+			stem := strings.TrimSuffix(filepath.Base(path), ".annotations.json")
+			id := stem[strings.LastIndex(stem, ".")+1:]
+			for _, a := range annotations {
+				labels[id] = append(labels[id], a.Labels...)
+			}
+			return nil
+		}
+		if !isRecordFile(path, "request") && !isRecordFile(path, "response") && !isRecordFile(path, "pair") && !isRecordFile(path, "skip") {
+			return nil
+		}
+
+		content, err := readRecordFile(path)
+		if err != nil {
+			return err
+		}
+		var record indexedRecord
+		if err := json.Unmarshal(content, &record); err != nil {
+			log.Printf("Error while unmarshalling %s: %s", path, err)
+			return nil
+		}
+		request, response := &record, &record
+		if record.Request != nil {
+			request, response = record.Request, record.Response
+		}
+		entry := indexEntry{id: record.ID, file: file, date: record.DateUnixNano, values: map[string][]string{}}
+		if !isRecordFile(path, "response") {
+			entry.req = fmt.Sprintf("[%s] %s http://%s%s", request.RemoteAddr, request.Method, request.Host, request.URI)
+			requests[record.ID] = entry.req
+			entry.values["path"] = []string{request.Path}
+		}
+		if response != nil && response.StatusCode != 0 {
+			entry.values["status"] = []string{strconv.Itoa(response.StatusCode)}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+
+	for i := range entries {
+		if entries[i].req == "" {
+			entries[i].req = requests[entries[i].id]
+		}
+		entries[i].values["label"] = labels[entries[i].id]
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].date < entries[j].date
+	})
+	return entries, err
+}
+
+// writeLines replaces a file with lines, through a temporary file.
+func writeLines(file string, lines []string) error {
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	for _, line := range lines {
+		writer.WriteString(line + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// writeSecondaryIndexes writes the index of each secondary key.
+func writeSecondaryIndexes(dir string, entries []indexEntry) error {
+	for _, key := range secondaryIndexes {
+		lines := []string{}
+		for _, entry := range entries {
+			for _, value := range entry.values[key] {
+				lines = append(lines, value+"\t"+entry.id+"\t"+entry.file)
+			}
+		}
+		sort.Strings(lines)
+		if err := writeLines(filepath.Join(dir, "index."+key+".log"), lines); err != nil {
+			return err
+		}
+		log.Printf("Indexed: %d line(s) in index.%s.log.", len(lines), key)
+	}
+	return nil
+}
+
+// compactIndex removes the duplicated lines of an index and the ones whose
+// record file, at column, no longer exists.
+func compactIndex(dir, name string, column int, sorted bool) error {
+	file := filepath.Join(dir, name)
+	lines, err := readLines(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	kept := []string{}
+	seen := map[string]bool{}
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 3)
+		if seen[line] || len(fields) <= column {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, fields[column])); err != nil {
+			continue
+		}
+		seen[line] = true
+		kept = append(kept, line)
+	}
+	if sorted {
+		sort.Strings(kept)
+	}
+	log.Printf("Compacted: %d of %d line(s) kept in %s.", len(kept), len(lines), name)
+	return writeLines(file, kept)
+}
+
+func indexRecords() {
+	if len(os.Args) < 3 || (os.Args[2] != "rebuild" && os.Args[2] != "compact") {
+		log.Fatal("Expected `rebuild` or `compact` index action.")
+	}
+	action := os.Args[2]
+	indexer := flag.NewFlagSet("index "+action, flag.PanicOnError)
+	dir := indexer.String("dir", ".", "Directory of the records and of their index.")
+	indexer.Parse(os.Args[3:])
+
+	log.Printf("  action: %s", action)
+	log.Printf("  dir: %s", *dir)
+
+	if action == "compact" {
+		if err := compactIndex(*dir, "index.log", 1, false); err != nil {
+			log.Fatalf("Error while compacting index.log: %s", err)
+		}
+		for _, key := range secondaryIndexes {
+			if err := compactIndex(*dir, "index."+key+".log", 2, true); err != nil {
+				log.Fatalf("Error while compacting index.%s.log: %s", key, err)
+			}
+		}
+		return
+	}
+
+	entries, err := scanIndexEntries(*dir)
+	if err != nil {
+		log.Fatalf("Error while reading records: %s", err)
+	}
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.line()
+	}
+	if err := writeLines(filepath.Join(*dir, "index.log"), lines); err != nil {
+		log.Fatalf("Error while writing index.log: %s", err)
+	}
+	log.Printf("Indexed: %d line(s) in index.log.", len(lines))
+	if err := writeSecondaryIndexes(*dir, entries); err != nil {
+		log.Fatalf("Error while writing secondary indexes: %s", err)
+	}
+}
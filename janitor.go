@@ -0,0 +1,181 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const janitorInterval = time.Minute
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// parseSize parses a size like `50GB` or `512M`, units being powers of 1024.
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size: %s", value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+type janitorFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func listRecordFiles(dir string) ([]janitorFile, error) {
+	files := []janitorFile{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !(isRecordFile(path, "request") || isRecordFile(path, "response") || isRecordFile(path, "pair") || isRecordFile(path, "skip") || isRecordFile(path, "annotations")) {
+			return nil
+		}
+		files = append(files, janitorFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, err
+}
+
+func removeEmptyDirs(dir string, removed map[string]bool) {
+	dirs := map[string]bool{}
+	for path := range removed {
+		for parent := filepath.Dir(path); parent != "." && parent != dir && parent != "/"; parent = filepath.Dir(parent) {
+			dirs[parent] = true
+		}
+	}
+	sorted := []string{}
+	for path := range dirs {
+		sorted = append(sorted, path)
+	}
+	// Deepest directories first, so that parents can be removed once empty.
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	for _, path := range sorted {
+		os.Remove(path)
+	}
+}
+
+// trimIndex removes from the index the lines referencing removed record files.
+func (ghr goHRec) trimIndex(removed map[string]bool) {
+	if !ghr.index || ghr.indexFile == nil {
+		return
+	}
+
+	ghr.indexMutex.Lock()
+	defer ghr.indexMutex.Unlock()
+
+	if _, err := ghr.indexFile.Seek(0, io.SeekStart); err != nil {
+		ghr.logError(errorStorage, "Error while trimming index", err)
+		return
+	}
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(ghr.indexFile)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.SplitN(line, "\t", 3); len(fields) > 1 && removed[filepath.Clean(fields[1])] {
+			continue
+		}
+		kept.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		ghr.logError(errorStorage, "Error while trimming index", err)
+		return
+	}
+	if err := ghr.indexFile.Truncate(0); err != nil {
+		ghr.logError(errorStorage, "Error while trimming index", err)
+		return
+	}
+	if _, err := ghr.indexFile.Write(kept.Bytes()); err != nil {
+		ghr.logError(errorStorage, "Error while trimming index", err)
+	}
+}
+
+// clean removes the record files older than the retention and then the oldest
+// ones until the disk usage fits the maximum.
+func (ghr goHRec) clean() {
+	files, err := listRecordFiles(".")
+	if err != nil {
+		ghr.logError(errorStorage, "Error while listing records", err)
+	}
+
+	var usage int64
+	for _, file := range files {
+		usage += file.size
+	}
+
+	removed := map[string]bool{}
+	now := time.Now()
+	for _, file := range files {
+		retention := ghr.retentionOf(file.path)
+		expired := retention > 0 && file.modTime.Before(now.Add(-retention))
+		oversized := ghr.maxDiskUsage > 0 && usage > ghr.maxDiskUsage
+		if !expired && !oversized {
+			// Younger files may still expire sooner with tenant retentions.
+			if ghr.tenants.hasRetention() {
+				continue
+			}
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			ghr.logError(errorStorage, "Error while removing record", err, "file", file.path)
+			continue
+		}
+		usage -= file.size
+		removed[filepath.Clean(file.path)] = true
+		if ghr.tenants != nil {
+			delete(ghr.tenants.files, file.path)
+		}
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+	removeEmptyDirs(".", removed)
+	ghr.trimIndex(removed)
+	ghr.log(slog.LevelInfo, "Cleaned", "removed", len(removed), "usage", usage)
+}
+
+func (ghr goHRec) janitor() {
+	for {
+		ghr.clean()
+		time.Sleep(janitorInterval)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a minimal JSONPath subset: `$.field`, `$.field.sub`, `$.list[0]`,
+// `$.list[*].field` and `$.*`.
+type jsonPath struct {
+	raw      string
+	segments []string
+}
+
+func parseJSONPath(value string) (jsonPath, error) {
+	path := jsonPath{raw: value}
+	if !strings.HasPrefix(value, "$") {
+		return path, fmt.Errorf("JSON path must start with `$`: %s", value)
+	}
+	rest := value[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return path, fmt.Errorf("Empty segment in JSON path: %s", value)
+			}
+			path.segments = append(path.segments, rest[1:end+1])
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return path, fmt.Errorf("Unclosed `[` in JSON path: %s", value)
+			}
+			segment := strings.Trim(rest[1:end], `'"`)
+			if segment == "" {
+				return path, fmt.Errorf("Empty segment in JSON path: %s", value)
+			}
+			path.segments = append(path.segments, segment)
+			rest = rest[end+1:]
+		default:
+			return path, fmt.Errorf("Unexpected character `%c` in JSON path: %s", rest[0], value)
+		}
+	}
+	return path, nil
+}
+
+// replace calls fn on each value matched by the path and stores its result in place.
+func (jp jsonPath) replace(doc interface{}, fn func(interface{}) interface{}) interface{} {
+	return jsonPathReplace(doc, jp.segments, fn)
+}
+
+func jsonPathReplace(node interface{}, segments []string, fn func(interface{}) interface{}) interface{} {
+	if len(segments) == 0 {
+		return fn(node)
+	}
+	segment, rest := segments[0], segments[1:]
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if segment == "*" {
+			for key, child := range value {
+				value[key] = jsonPathReplace(child, rest, fn)
+			}
+		} else if child, ok := value[segment]; ok {
+			value[segment] = jsonPathReplace(child, rest, fn)
+		}
+	case []interface{}:
+		if segment == "*" {
+			for i, child := range value {
+				value[i] = jsonPathReplace(child, rest, fn)
+			}
+		} else if i, err := strconv.Atoi(segment); err == nil && i >= 0 && i < len(value) {
+			value[i] = jsonPathReplace(value[i], rest, fn)
+		}
+	}
+	return node
+}
+
+// lookup returns the values matched by the path.
+func (jp jsonPath) lookup(doc interface{}) []interface{} {
+	out := []interface{}{}
+	jp.replace(doc, func(value interface{}) interface{} {
+		out = append(out, value)
+		return value
+	})
+	return out
+}
+
+func decodeJSON(text string) (interface{}, bool) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return nil, false
+	}
+	return doc, true
+}
+
+func encodeJSON(doc interface{}) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+type arrayJSONPathFlag []jsonPath
+
+func (ajpf *arrayJSONPathFlag) Redact(text string) string {
+	if len(*ajpf) == 0 {
+		return text
+	}
+	doc, ok := decodeJSON(text)
+	if !ok {
+		return text
+	}
+	for _, path := range *ajpf {
+		doc = path.replace(doc, func(interface{}) interface{} {
+			return redactedString
+		})
+	}
+	return encodeJSON(doc)
+}
+
+func (ajpf *arrayJSONPathFlag) Set(value string) error {
+	path, err := parseJSONPath(value)
+	if err != nil {
+		return err
+	}
+	*ajpf = append(*ajpf, path)
+	return nil
+}
+
+func (ajpf *arrayJSONPathFlag) String() string {
+	if ajpf == nil {
+		return "[]"
+	}
+	out := []string{}
+	for _, item := range *ajpf {
+		out = append(out, "`"+item.raw+"`")
+	}
+	return "[ " + strings.Join(out, ", ") + " ]"
+}
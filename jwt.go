@@ -0,0 +1,80 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// authInfo is the decoded, but not verified, JWT bearer token of a request.
+type authInfo struct {
+	Scheme string
+	Header map[string]interface{}
+	Claims map[string]interface{}
+}
+
+// jwtDecoder decodes bearer tokens, redacting the values of some claims.
+type jwtDecoder struct {
+	redactClaims map[string]bool
+}
+
+func makeJWTDecoder(enabled bool, redactClaims string) *jwtDecoder {
+	if !enabled {
+		return nil
+	}
+	jd := &jwtDecoder{redactClaims: map[string]bool{}}
+	for _, name := range strings.Split(redactClaims, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			jd.redactClaims[name] = true
+		}
+	}
+	return jd
+}
+
+// decodeJWTPart decodes a base64url encoded JSON object of a token.
+func decodeJWTPart(part string) (map[string]interface{}, bool) {
+	content, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return nil, false
+	}
+	doc, ok := decodeJSON(string(content))
+	if !ok {
+		return nil, false
+	}
+	object, ok := doc.(map[string]interface{})
+	return object, ok
+}
+
+// decode returns the Auth section of a request, nil when it has no JWT bearer
+// token.
+func (jd *jwtDecoder) decode(r *http.Request) *authInfo {
+	if jd == nil {
+		return nil
+	}
+	split := strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
+	if len(split) != 2 || !strings.EqualFold(split[0], "Bearer") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSpace(split[1]), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	header, ok := decodeJWTPart(parts[0])
+	if !ok {
+		return nil
+	}
+	claims, ok := decodeJWTPart(parts[1])
+	if !ok {
+		return nil
+	}
+	for name := range claims {
+		if jd.redactClaims[name] {
+			claims[name] = redactedString
+		}
+	}
+	return &authInfo{Scheme: "Bearer", Header: header, Claims: claims}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kafkaProduce  = 0
+	kafkaMetadata = 3
+	kafkaTimeout  = 10 * time.Second
+)
+
+var kafkaCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaSink is a minimal Kafka producer publishing each record, keyed by its
+// ID, with the leader acknowledging it.
+type kafkaSink struct {
+	brokers     []string
+	topic       string
+	mutex       sync.Mutex
+	correlation int32
+	leaders     []string
+	conns       map[string]net.Conn
+}
+
+func makeKafkaSink(brokers, topic string) (*kafkaSink, error) {
+	ks := &kafkaSink{topic: topic, conns: map[string]net.Conn{}}
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			ks.brokers = append(ks.brokers, broker)
+		}
+	}
+	if len(ks.brokers) == 0 || topic == "" {
+		return nil, fmt.Errorf("--sink kafka requires --kafka-brokers and --kafka-topic.")
+	}
+	// Leaders may not be available yet when the topic is auto-created.
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = ks.refresh(); err == nil {
+			return ks, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return nil, fmt.Errorf("Error while fetching Kafka metadata: %s", err)
+}
+
+// kafkaWriter encodes the big-endian primitives of the Kafka protocol.
+type kafkaWriter struct{ bytes.Buffer }
+
+func (kw *kafkaWriter) int8(v int8)   { kw.WriteByte(byte(v)) }
+func (kw *kafkaWriter) int16(v int16) { binary.Write(kw, binary.BigEndian, v) }
+func (kw *kafkaWriter) int32(v int32) { binary.Write(kw, binary.BigEndian, v) }
+func (kw *kafkaWriter) int64(v int64) { binary.Write(kw, binary.BigEndian, v) }
+func (kw *kafkaWriter) string(v string) {
+	kw.int16(int16(len(v)))
+	kw.WriteString(v)
+}
+func (kw *kafkaWriter) varint(v int64) {
+	kw.Write(binary.AppendVarint(nil, v))
+}
+func (kw *kafkaWriter) varbytes(v []byte) {
+	kw.varint(int64(len(v)))
+	kw.Write(v)
+}
+
+// kafkaReader decodes the big-endian primitives of the Kafka protocol, the
+// first error being kept.
+type kafkaReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (kr *kafkaReader) read(v interface{}) {
+	if kr.err == nil {
+		kr.err = binary.Read(kr.r, binary.BigEndian, v)
+	}
+}
+func (kr *kafkaReader) int8() (v int8)   { kr.read(&v); return }
+func (kr *kafkaReader) int16() (v int16) { kr.read(&v); return }
+func (kr *kafkaReader) int32() (v int32) { kr.read(&v); return }
+func (kr *kafkaReader) int64() (v int64) { kr.read(&v); return }
+func (kr *kafkaReader) string() string {
+	n := kr.int16()
+	if n < 0 || kr.err != nil {
+		return ""
+	}
+	v := make([]byte, n)
+	if _, err := io.ReadFull(kr.r, v); err != nil && kr.err == nil {
+		kr.err = err
+	}
+	return string(v)
+}
+
+// request sends a request to a broker and returns its response body.
+func (ks *kafkaSink) request(addr string, apiKey, apiVersion int16, body []byte) (*kafkaReader, error) {
+	conn, ok := ks.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = net.DialTimeout("tcp", addr, kafkaTimeout); err != nil {
+			return nil, err
+		}
+		ks.conns[addr] = conn
+	}
+	ks.correlation++
+
+	var kw kafkaWriter
+	kw.int16(apiKey)
+	kw.int16(apiVersion)
+	kw.int32(ks.correlation)
+	kw.string("gohrec")
+	kw.Write(body)
+
+	conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	message := binary.BigEndian.AppendUint32(nil, uint32(kw.Len()))
+	if _, err := conn.Write(append(message, kw.Bytes()...)); err != nil {
+		ks.disconnect(addr)
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		ks.disconnect(addr)
+		return nil, err
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		ks.disconnect(addr)
+		return nil, err
+	}
+	kr := &kafkaReader{r: bytes.NewReader(response)}
+	if correlation := kr.int32(); correlation != ks.correlation {
+		ks.disconnect(addr)
+		return nil, fmt.Errorf("unexpected correlation ID %d", correlation)
+	}
+	return kr, nil
+}
+
+func (ks *kafkaSink) disconnect(addr string) {
+	if conn, ok := ks.conns[addr]; ok {
+		conn.Close()
+		delete(ks.conns, addr)
+	}
+}
+
+// refresh fetches the leader of each partition of the topic from the first
+// reachable broker, using Metadata v4.
+func (ks *kafkaSink) refresh() error {
+	var kw kafkaWriter
+	kw.int32(1)
+	kw.string(ks.topic)
+	kw.int8(1)
+
+	var err error
+	for _, broker := range ks.brokers {
+		var kr *kafkaReader
+		if kr, err = ks.request(broker, kafkaMetadata, 4, kw.Bytes()); err != nil {
+			continue
+		}
+		kr.int32()
+		nodes := map[int32]string{}
+		for i := kr.int32(); i > 0 && kr.err == nil; i-- {
+			id, host, port := kr.int32(), kr.string(), kr.int32()
+			kr.string()
+			nodes[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+		kr.string()
+		kr.int32()
+		leaders := map[int32]string{}
+		for i := kr.int32(); i > 0 && kr.err == nil; i-- {
+			code := kr.int16()
+			kr.string()
+			kr.int8()
+			if code != 0 {
+				return fmt.Errorf("topic `%s` error code %d", ks.topic, code)
+			}
+			for j := kr.int32(); j > 0 && kr.err == nil; j-- {
+				kr.int16()
+				partition, leader := kr.int32(), kr.int32()
+				for k := kr.int32(); k > 0 && kr.err == nil; k-- {
+					kr.int32()
+				}
+				for k := kr.int32(); k > 0 && kr.err == nil; k-- {
+					kr.int32()
+				}
+				leaders[partition] = nodes[leader]
+			}
+		}
+		if kr.err != nil {
+			return kr.err
+		}
+		if len(leaders) == 0 {
+			return fmt.Errorf("topic `%s` has no partition", ks.topic)
+		}
+		ks.leaders = make([]string, len(leaders))
+		for partition, addr := range leaders {
+			if int(partition) < len(ks.leaders) {
+				ks.leaders[partition] = addr
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// recordBatch encodes a single record as a v2 record batch.
+func recordBatch(record sinkRecord) []byte {
+	var rec kafkaWriter
+	rec.int8(0)
+	rec.varint(0)
+	rec.varint(0)
+	rec.varbytes([]byte(record.ID))
+	rec.varbytes(record.Content)
+	rec.varint(2)
+	rec.varbytes([]byte("kind"))
+	rec.varbytes([]byte(record.Kind))
+	rec.varbytes([]byte("name"))
+	rec.varbytes([]byte(record.Name))
+
+	var batch kafkaWriter
+	batch.int16(0)
+	batch.int32(0)
+	batch.int64(record.Date.UnixMilli())
+	batch.int64(record.Date.UnixMilli())
+	batch.int64(-1)
+	batch.int16(-1)
+	batch.int32(-1)
+	batch.int32(1)
+	batch.varbytes(rec.Bytes())
+
+	var kw kafkaWriter
+	kw.int64(0)
+	kw.int32(int32(4 + 1 + 4 + batch.Len()))
+	kw.int32(-1)
+	kw.int8(2)
+	kw.int32(int32(crc32.Checksum(batch.Bytes(), kafkaCRC)))
+	kw.Write(batch.Bytes())
+	return kw.Bytes()
+}
+
+// produce sends a record to the leader of its partition, using Produce v3.
+func (ks *kafkaSink) produce(record sinkRecord) error {
+	hash := fnv.New32a()
+	hash.Write([]byte(record.ID))
+	partition := int32(hash.Sum32() % uint32(len(ks.leaders)))
+	batch := recordBatch(record)
+
+	var kw kafkaWriter
+	kw.int16(-1)
+	kw.int16(1)
+	kw.int32(int32(kafkaTimeout / time.Millisecond))
+	kw.int32(1)
+	kw.string(ks.topic)
+	kw.int32(1)
+	kw.int32(partition)
+	kw.int32(int32(len(batch)))
+	kw.Write(batch)
+
+	kr, err := ks.request(ks.leaders[partition], kafkaProduce, 3, kw.Bytes())
+	if err != nil {
+		return err
+	}
+	kr.int32()
+	kr.string()
+	kr.int32()
+	kr.int32()
+	code := kr.int16()
+	if kr.err != nil {
+		return kr.err
+	}
+	if code != 0 {
+		return fmt.Errorf("produce error code %d", code)
+	}
+	return nil
+}
+
+// publish produces a record, refreshing the partition leaders and retrying
+// once on failure.
+func (ks *kafkaSink) publish(record sinkRecord) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if err := ks.produce(record); err == nil {
+		return nil
+	}
+	if err := ks.refresh(); err != nil {
+		return err
+	}
+	return ks.produce(record)
+}
+
+func (ks *kafkaSink) close() error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	for addr := range ks.conns {
+		ks.disconnect(addr)
+	}
+	return nil
+}
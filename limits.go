@@ -0,0 +1,70 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// connectionLimiter tracks open connections and rejects requests received
+// while more than max connections are open.
+type connectionLimiter struct {
+	max, active int64
+}
+
+func (cl *connectionLimiter) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		metrics.Add("connections_active", 1)
+		atomic.AddInt64(&cl.active, 1)
+	case http.StateClosed, http.StateHijacked:
+		metrics.Add("connections_active", -1)
+		atomic.AddInt64(&cl.active, -1)
+	}
+}
+
+func headerSize(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
+}
+
+// guard rejects requests exceeding the connection and header size limits.
+func (cl *connectionLimiter) guard(ghr goHRec, maxHeaderBytes int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.Add("requests_total", 1)
+
+		if cl.max > 0 && atomic.LoadInt64(&cl.active) > cl.max {
+			metrics.Add("connections_rejected", 1)
+			ghr.log(slog.LevelWarn, "Skipped: too many connections.", "request", makeRequestName(r))
+			ghr.recordSkip(r, "too-many-connections")
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, "Skipped: too many connections.")
+			return
+		}
+
+		if maxHeaderBytes > 0 && headerSize(r) > maxHeaderBytes {
+			metrics.Add("headers_too_large", 1)
+			ghr.log(slog.LevelWarn, "Skipped: headers too large.", "request", makeRequestName(r))
+			ghr.recordSkip(r, "too-large")
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+			fmt.Fprintln(w, "Skipped: headers too large.")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
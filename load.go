@@ -0,0 +1,185 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadSettings controls the pace of a replay run as a load test, either at
+// a fixed rate, or with the recorded gaps between requests divided by speed.
+type loadSettings struct {
+	rps         float64
+	concurrency int
+	duration    time.Duration
+	speed       float64
+}
+
+func (ls loadSettings) enabled() bool {
+	return ls.rps > 0 || ls.concurrency > 1 || ls.duration > 0 || ls.speed > 0
+}
+
+// parseSpeed parses a replay speed multiplier, like `2x` or `0.5`.
+func parseSpeed(value string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 || math.IsInf(speed, 0) {
+		return 0, fmt.Errorf("Invalid --speed `%s`, expected a positive multiplier like `2x`.", value)
+	}
+	return speed, nil
+}
+
+// loadResults aggregates the outcomes of the requests of a load test.
+type loadResults struct {
+	mutex     sync.Mutex
+	sent      int
+	errors    int
+	statuses  map[string]int
+	latencies []float64
+}
+
+func (lr *loadResults) add(statusCode int, latency time.Duration, err error) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	lr.sent++
+	if err != nil {
+		lr.errors++
+		lr.statuses["error"]++
+		return
+	}
+	lr.statuses[strconv.Itoa(statusCode)]++
+	lr.latencies = append(lr.latencies, float64(latency)/float64(time.Millisecond))
+}
+
+func (lr *loadResults) log(elapsed time.Duration, workers string) {
+	log.Printf("Sent %d request(s) in %s (%.1f/s) with %s: %d error(s).", lr.sent, elapsed.Round(time.Millisecond), float64(lr.sent)/elapsed.Seconds(), workers, lr.errors)
+	for _, count := range sortedCounts(lr.statuses, 0) {
+		log.Printf("  %s: %d", count.Name, count.Count)
+	}
+	if p := percentiles(lr.latencies); p != nil {
+		log.Printf("Latency (ms): p50 %.1f, p90 %.1f, p95 %.1f, p99 %.1f, max %.1f", p.P50, p.P90, p.P95, p.P99, p.Max)
+	}
+}
+
+// redoFile sends the request of a file, adding its outcome to the results.
+func (rd redoer) redoFile(file redoFile, results *loadResults) {
+	start := time.Now()
+	statusCode, err := rd.do(file.record)
+	if err != nil && rd.verbose {
+		log.Printf("%s (%s)", err, file.name)
+	}
+	results.add(statusCode, time.Since(start), err)
+}
+
+// do sends a request, discarding its response.
+func (rd redoer) do(record redoRecord) (int, error) {
+	req, err := rd.prepare(record, "")
+	if err != nil {
+		return 0, err
+	}
+	resp, err := rd.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// redoLoad redoes the requests at the rate of ls.rps (as fast as possible if
+// 0) with ls.concurrency workers, cycling through them for ls.duration, or
+// once without duration, and logs a summary of the responses.
+func (rd redoer) redoLoad(files []redoFile, ls loadSettings) {
+	if len(files) == 0 {
+		return
+	}
+	if ls.speed > 0 {
+		rd.redoTimed(files, ls.speed)
+		return
+	}
+	if ls.concurrency < 1 {
+		ls.concurrency = 1
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = ls.concurrency
+	transport.TLSClientConfig = rd.tlsConfig
+	transport.Proxy = rd.proxy
+	rd.client.Transport = rd.retrier.wrap(rd.guard.wrap(transport))
+
+	results := &loadResults{statuses: map[string]int{}}
+	jobs := make(chan redoFile)
+	var wg sync.WaitGroup
+	for i := 0; i < ls.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				rd.redoFile(file, results)
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := 0; ; i++ {
+		if ls.duration > 0 && time.Since(start) >= ls.duration {
+			break
+		}
+		if ls.duration == 0 && i == len(files) {
+			break
+		}
+		if ls.rps > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(i) * float64(time.Second) / ls.rps))))
+		}
+		jobs <- files[i%len(files)]
+	}
+	close(jobs)
+	wg.Wait()
+	results.log(time.Since(start), fmt.Sprintf("%d worker(s)", ls.concurrency))
+}
+
+// redoTimed redoes each request at its recorded offset from the first one
+// divided by speed, without waiting for the previous ones to complete, so
+// that the original pacing and concurrency are reproduced.
+func (rd redoer) redoTimed(files []redoFile, speed float64) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 100
+	transport.TLSClientConfig = rd.tlsConfig
+	transport.Proxy = rd.proxy
+	rd.client.Transport = rd.retrier.wrap(rd.guard.wrap(transport))
+
+	results := &loadResults{statuses: map[string]int{}}
+	first := files[0].record.DateUnixNano
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	start := time.Now()
+	for _, file := range files {
+		offset := time.Duration(float64(file.record.DateUnixNano-first) / speed)
+		time.Sleep(time.Until(start.Add(offset)))
+		wg.Add(1)
+		go func(file redoFile) {
+			defer wg.Done()
+			mutex.Lock()
+			if inFlight++; inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mutex.Unlock()
+			rd.redoFile(file, results)
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+		}(file)
+	}
+	wg.Wait()
+	results.log(time.Since(start), fmt.Sprintf("recorded timing at %gx (up to %d concurrent)", speed, maxInFlight))
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// makeLogger returns the leveled logger of a component, writing text or JSON
+// lines to the standard error. With the json format, the output of the log
+// package is written as JSON lines too.
+func makeLogger(level, format, component string) (*slog.Logger, error) {
+	minLevel, ok := logLevels[level]
+	if !ok {
+		return nil, fmt.Errorf("Unknown --log-level `%s`, expected `debug`, `info`, `warn` or `error`.", level)
+	}
+	options := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+		slog.SetDefault(slog.New(handler).With("component", "main"))
+	default:
+		return nil, fmt.Errorf("Unknown --log-format `%s`, expected `text` or `json`.", format)
+	}
+	return slog.New(handler).With("component", component), nil
+}
+
+// log writes a message of a level, followed by its fields as key-value
+// pairs, like the `request` name, `id`, `path` or `duration` of a request.
+func (ghr goHRec) log(level slog.Level, msg string, fields ...interface{}) {
+	if ghr.logger != nil {
+		ghr.logger.Log(context.Background(), level, msg, fields...)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startProxy starts a recorder in proxy mode forwarding to target, or to
+// itself when target is empty.
+func startProxy(t *testing.T, target string) (*httptest.Server, goHRec) {
+	server := httptest.NewUnstartedServer(nil)
+	if target == "" {
+		target = "http://" + server.Listener.Addr().String()
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghr := goHRec{
+		proxy:       true,
+		targetURL:   targetURL,
+		dateFormat:  "2006-01-02/15-04-05_",
+		maxBodySize: -1,
+		instanceID:  makeRequestID(server.Listener.Addr().String(), time.Now()),
+	}
+	server.Config.Handler = http.HandlerFunc(ghr.proxyHandler)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, ghr
+}
+
+func get(t *testing.T, u string) (*http.Response, string) {
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestProxyChainedToItselfIsLooping(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server, _ := startProxy(t, "")
+
+	resp, body := get(t, server.URL+"/loop")
+	if resp.StatusCode != http.StatusLoopDetected {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusLoopDetected, resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "loop detected") {
+		t.Fatalf("expected loop detected body, got %q", body)
+	}
+}
+
+func TestProxyChainedInstancesPassThrough(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var via []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		via = r.Header.Values(viaHeader)
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	second, secondGHR := startProxy(t, backend.URL)
+	first, firstGHR := startProxy(t, second.URL)
+
+	resp, body := get(t, first.URL+"/chained")
+	if resp.StatusCode != http.StatusOK || body != "backend" {
+		t.Fatalf("expected the backend response, got %d: %s", resp.StatusCode, body)
+	}
+	if strings.Join(via, ",") != firstGHR.instanceID+","+secondGHR.instanceID {
+		t.Fatalf("expected the backend to be reached via %s then %s, got %v", firstGHR.instanceID, secondGHR.instanceID, via)
+	}
+}
+
+func TestProxyRecordsAreNotMarked(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	server, _ := startProxy(t, backend.URL)
+
+	if resp, body := get(t, server.URL+"/marked"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	records := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !strings.HasSuffix(path, ".request.json") {
+			return err
+		}
+		records++
+		content, err := ioutil.ReadFile(path)
+		if err == nil && strings.Contains(string(content), viaHeader) {
+			t.Errorf("expected %s to be recorded without %s: %s", path, viaHeader, content)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != 1 {
+		t.Fatalf("expected 1 request record, got %d", records)
+	}
+}
+
+func TestNotificationsToItselfAreNotRecorded(t *testing.T) {
+	t.Chdir(t.TempDir())
+	ghr := goHRec{dateFormat: defaultDateFormat, maxBodySize: -1, respondStatus: http.StatusCreated, instanceID: "recorder"}
+	server := httptest.NewServer(http.HandlerFunc(ghr.handler))
+	defer server.Close()
+	n := makeNotifier(server.URL+"/notify", 1, ghr.instanceID, ghr.logError)
+
+	n.notify(notification{ID: "id", Kind: "request", Filename: "file"})
+	n.close()
+	files, err := filepath.Glob("*/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected the notification not to be recorded, got %v", files)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// malformedMaxRaw is the maximum size of the raw bytes of a malformed request
+// kept in its record.
+const malformedMaxRaw = 64 << 10
+
+// malformedErrorHeaders are the headers of the responses written by the Go
+// HTTP server itself to requests it cannot parse, which handlers cannot
+// write as their headers are sorted.
+var malformedErrorHeaders = []byte("\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n\r\n")
+
+// malformedRecord is recorded for a request rejected by the Go HTTP server
+// before reaching any handler, like one with an invalid header or an
+// oversized line, with the raw bytes received.
+type malformedRecord struct {
+	ID            string
+	Date, DateUTC time.Time
+	DateUnixNano  int64
+	RemoteAddr    string
+	ParseError    string
+	StatusCode    int
+	Raw           string
+	RawEncoding   string `json:",omitempty"`
+	RawTruncated  bool   `json:",omitempty"`
+}
+
+type malformedListener struct {
+	net.Listener
+	ghr goHRec
+}
+
+func (ml malformedListener) Accept() (net.Conn, error) {
+	conn, err := ml.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return &malformedConn{Conn: conn, ghr: ml.ghr}, nil
+}
+
+// malformedConn keeps the raw bytes read since the previous response written
+// on a connection, and records them when the server rejects them. The bytes
+// read before the previous response are kept too, as pipelined requests are
+// read along with the ones before them.
+type malformedConn struct {
+	net.Conn
+	ghr                      goHRec
+	mutex                    sync.Mutex
+	raw, previous            []byte
+	truncated, prevTruncated bool
+}
+
+func (mc *malformedConn) Read(p []byte) (int, error) {
+	n, err := mc.Conn.Read(p)
+	mc.mutex.Lock()
+	if room := malformedMaxRaw - len(mc.raw); n > room {
+		mc.raw = append(mc.raw, p[:room]...)
+		mc.truncated = true
+	} else {
+		mc.raw = append(mc.raw, p[:n]...)
+	}
+	mc.mutex.Unlock()
+	return n, err
+}
+
+func (mc *malformedConn) Write(p []byte) (int, error) {
+	mc.mutex.Lock()
+	raw, truncated := mc.raw, mc.truncated
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw, truncated = append(mc.previous, raw...), mc.prevTruncated || truncated
+	}
+	mc.previous, mc.prevTruncated = mc.raw, mc.truncated
+	mc.raw, mc.truncated = nil, false
+	mc.mutex.Unlock()
+
+	if bytes.HasPrefix(p, []byte("HTTP/1.1 ")) {
+		if i := bytes.Index(p, malformedErrorHeaders); i > -1 && len(bytes.TrimSpace(raw)) > 0 {
+			mc.ghr.recordMalformed(mc.RemoteAddr().String(), string(p[len("HTTP/1.1 "):i]), raw, truncated)
+		}
+	}
+	return mc.Conn.Write(p)
+}
+
+// recordMalformed saves the raw bytes of a request rejected with status, like
+// `400 Bad Request: malformed Host header`.
+func (ghr goHRec) recordMalformed(remoteAddr string, status string, raw []byte, truncated bool) {
+	received := time.Now()
+	req := fmt.Sprintf("[%s] MALFORMED", remoteAddr)
+	record := malformedRecord{
+		ID:           ghr.makeRequestID(req, received),
+		Date:         received,
+		DateUTC:      received.UTC(),
+		DateUnixNano: received.UnixNano(),
+		RemoteAddr:   remoteAddr,
+		ParseError:   status,
+		RawTruncated: truncated,
+	}
+	if len(status) >= 3 {
+		record.StatusCode, _ = strconv.Atoi(status[:3])
+	}
+	if utf8.Valid(raw) {
+		record.Raw = string(raw)
+	} else {
+		record.Raw = base64.StdEncoding.EncodeToString(raw)
+		record.RawEncoding = "base64"
+	}
+	ghr.logError(errorParse, "Malformed request", errors.New(status), "remote", remoteAddr)
+
+	json, err := json.MarshalIndent(record, "", " ")
+	if err != nil {
+		ghr.logError(errorStorage, "Error while serializing record", err, "request", req)
+		return
+	}
+	ghr.saveJSON(json, record.ID, received, "malformed", req)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type manifestEntry struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// manifest lists the records written during a session, with their sizes and
+// hashes, so that archives can be checked for completeness and bit-rot.
+type manifest struct {
+	Name             string `json:",omitempty"`
+	Started, Stopped time.Time
+	Files            []manifestEntry
+	mutex            sync.Mutex
+}
+
+func (m *manifest) add(name string, content []byte) {
+	if m == nil {
+		return
+	}
+	hash := sha256.Sum256(content)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Files = append(m.Files, manifestEntry{Name: name, Size: int64(len(content)), SHA256: hex.EncodeToString(hash[:])})
+}
+
+// write saves the manifest next to the records, named after the session
+// start with the date format of records.
+func (m *manifest) write(dateFormat string, writeFile func(string, []byte) error, logError errorLogger) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Stopped = time.Now()
+	m.writeTo(m.Started.Format(dateFormat)+"manifest.json", writeFile, logError)
+}
+
+// writeTo saves the manifest to a file, its mutex being held.
+func (m *manifest) writeTo(file string, writeFile func(string, []byte) error, logError errorLogger) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		logError(errorStorage, "Error while writing manifest", err, "file", file)
+		return
+	}
+	content, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		logError(errorStorage, "Error while serializing manifest", err, "file", file)
+		return
+	}
+	if err := writeFile(file, content); err != nil {
+		logError(errorStorage, "Error while writing manifest", err, "file", file)
+		return
+	}
+	log.Printf("Manifest of %d record(s) written to %s", len(m.Files), file)
+}
+
+func hashFile(file string) (int64, string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	return size, hex.EncodeToString(hash.Sum(nil)), err
+}
+
+func verifyManifest() {
+	verifier := flag.NewFlagSet("verify-manifest", flag.PanicOnError)
+	manifestFile := verifier.String("manifest", "", "Manifest file to verify.")
+	dir := verifier.String("dir", ".", "Directory the record filenames of the manifest are relative to.")
+	verifier.Parse(os.Args[2:])
+
+	log.Printf("  manifest: %s", *manifestFile)
+	log.Printf("  dir: %s", *dir)
+
+	content, err := ioutil.ReadFile(*manifestFile)
+	if err != nil {
+		log.Fatalf("Error while reading manifest: %s", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		log.Fatalf("Error while unmarshalling manifest: %s", err)
+	}
+
+	failures := []string{}
+	for _, entry := range m.Files {
+		size, sum, err := hashFile(filepath.Join(*dir, entry.Name))
+		switch {
+		case os.IsNotExist(err):
+			failures = append(failures, fmt.Sprintf("missing: %s", entry.Name))
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("unreadable: %s (%s)", entry.Name, err))
+		case size != entry.Size || sum != entry.SHA256:
+			failures = append(failures, fmt.Sprintf("corrupted: %s", entry.Name))
+		}
+	}
+	for _, failure := range failures {
+		fmt.Println(failure)
+	}
+	if len(failures) > 0 {
+		log.Fatalf("Verified %d record(s): %d failure(s).", len(m.Files), len(failures))
+	}
+	log.Printf("Verified %d record(s): all valid.", len(m.Files))
+}
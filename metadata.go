@@ -0,0 +1,30 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const metaHeaderPrefix = "X-Gohrec-Meta-"
+
+// takeMetadata removes the X-Gohrec-Meta-* headers of a request, so they are
+// neither recorded as headers nor forwarded, and returns their values keyed
+// by the lowercased rest of their names.
+func takeMetadata(header http.Header) map[string]string {
+	var meta map[string]string
+	for name, values := range header {
+		if len(name) <= len(metaHeaderPrefix) || !strings.EqualFold(name[:len(metaHeaderPrefix)], metaHeaderPrefix) {
+			continue
+		}
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		meta[strings.ToLower(name[len(metaHeaderPrefix):])] = strings.Join(values, ", ")
+		header.Del(name)
+	}
+	return meta
+}
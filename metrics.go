@@ -0,0 +1,46 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"expvar"
+)
+
+// metrics are exposed as JSON on /debug/vars when --metrics is enabled.
+var metrics = expvar.NewMap("gohrec")
+
+func init() {
+	for _, name := range []string{
+		"cache_hits",
+		"cache_misses",
+		"canary_divergent",
+		"canary_errors",
+		"canary_requests",
+		"connections_active",
+		"connections_rejected",
+		"errors_client-abort",
+		"errors_parse",
+		"errors_redaction",
+		"errors_storage",
+		"errors_upstream",
+		"headers_too_large",
+		"notifications_dropped",
+		"notifications_failed",
+		"notifications_sent",
+		"rate_limited",
+		"records_failed",
+		"records_quarantined",
+		"records_saved",
+		"requests_total",
+		"retries_detected",
+		"secrets_detected",
+		"sla_checked",
+		"sla_violations",
+		"sla_violations_latency",
+		"sla_violations_status",
+	} {
+		metrics.Add(name, 0)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var strftimeLayouts = map[byte]string{'Y': "2006", 'm': "01", 'd': "02", 'H': "15", 'M': "04"}
+
+// strftime formats a date with the %Y, %m, %d, %H and %M directives of a
+// pattern, the rest of it being kept as is.
+func strftime(pattern string, date time.Time) string {
+	var out strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i == len(pattern)-1 {
+			out.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		if layout, ok := strftimeLayouts[pattern[i]]; ok {
+			out.WriteString(date.Format(layout))
+		} else if pattern[i] == '%' {
+			out.WriteByte('%')
+		} else {
+			out.WriteString(pattern[i-1 : i+1])
+		}
+	}
+	return out.String()
+}
+
+// ndjsonLine returns a record as one line of JSON, its kind being added as
+// the first `Kind` field.
+func ndjsonLine(record sinkRecord) ([]byte, error) {
+	var line bytes.Buffer
+	if err := json.Compact(&line, record.Content); err != nil {
+		return nil, err
+	}
+	content := line.Bytes()
+	if len(content) > 2 && content[0] == '{' {
+		kind, _ := json.Marshal(record.Kind)
+		content = append([]byte(`{"Kind":`+string(kind)+`,`), content[1:]...)
+	}
+	return append(content, '\n'), nil
+}
+
+// ndjsonStore appends records as lines of files rotated according to the
+// date of the records.
+type ndjsonStore struct {
+	pattern string
+	mutex   sync.Mutex
+	name    string
+	file    *os.File
+}
+
+func makeNDJSONStore(pattern string) (*ndjsonStore, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("--store ndjson requires --file.")
+	}
+	return &ndjsonStore{pattern: pattern}, nil
+}
+
+func (ns *ndjsonStore) publish(record sinkRecord) error {
+	line, err := ndjsonLine(record)
+	if err != nil {
+		return err
+	}
+
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	if name := strftime(ns.pattern, record.Date); name != ns.name || ns.file == nil {
+		if ns.file != nil {
+			ns.file.Close()
+			ns.file = nil
+		}
+		if dir := filepath.Dir(name); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		if ns.file, err = os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return err
+		}
+		ns.name = name
+	}
+	_, err = ns.file.Write(line)
+	return err
+}
+
+func (ns *ndjsonStore) close() error {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	if ns.file == nil {
+		return nil
+	}
+	err := ns.file.Close()
+	ns.file = nil
+	return err
+}
+
+// stdoutStore writes records as lines of the standard output, to pipe them
+// into other tools, logs being written to the standard error.
+type stdoutStore struct {
+	mutex sync.Mutex
+}
+
+func (ss *stdoutStore) publish(record sinkRecord) error {
+	line, err := ndjsonLine(record)
+	if err != nil {
+		return err
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	_, err = os.Stdout.Write(line)
+	return err
+}
+
+func (ss *stdoutStore) close() error {
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	notifyAttempts     = 3
+	notifyRetryDelay   = time.Second
+	notifyDrainTimeout = 10 * time.Second
+)
+
+// notification is the summary of a saved record POSTed to --notify-url.
+type notification struct {
+	ID         string
+	Kind       string
+	Filename   string
+	Method     string `json:",omitempty"`
+	Path       string `json:",omitempty"`
+	StatusCode int    `json:",omitempty"`
+}
+
+// notifier POSTs notifications from a bounded queue, dropping them when it
+// is full so that recording is never slowed down, or once it is closed.
+type notifier struct {
+	url      string
+	client   http.Client
+	logError errorLogger
+	mutex    sync.Mutex
+	closed   bool
+	queue    chan notification
+	done     chan struct{}
+}
+
+func makeNotifier(url string, queueSize int, via string, logError errorLogger) *notifier {
+	if url == "" {
+		return nil
+	}
+	n := &notifier{
+		url:      url,
+		client:   http.Client{Timeout: 10 * time.Second, Transport: makeViaTransport(via, nil)},
+		logError: logError,
+		queue:    make(chan notification, queueSize),
+		done:     make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// notify queues a notification.
+func (n *notifier) notify(notif notification) {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		metrics.Add("notifications_dropped", 1)
+		log.Printf("Error while notifying %s: notifier closed, notification dropped.", notif.Filename)
+		return
+	}
+	select {
+	case n.queue <- notif:
+	default:
+		metrics.Add("notifications_dropped", 1)
+		log.Printf("Error while notifying %s: queue is full, notification dropped.", notif.Filename)
+	}
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for notif := range n.queue {
+		content, err := json.Marshal(notif)
+		if err != nil {
+			n.logError(errorUpstream, "Error while serializing notification", err, "file", notif.Filename)
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			if err = n.post(content); err == nil {
+				metrics.Add("notifications_sent", 1)
+				break
+			}
+			if attempt == notifyAttempts {
+				metrics.Add("notifications_failed", 1)
+				n.logError(errorUpstream, "Error while notifying", err, "file", notif.Filename)
+				break
+			}
+			time.Sleep(notifyRetryDelay << (attempt - 1))
+		}
+	}
+}
+
+func (n *notifier) post(content []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// close waits for the queued notifications to be sent, up to a timeout.
+func (n *notifier) close() {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	n.closed = true
+	close(n.queue)
+	n.mutex.Unlock()
+	select {
+	case <-n.done:
+	case <-time.After(notifyDrainTimeout):
+		log.Printf("Error while notifying: %d notification(s) left unsent.", len(n.queue))
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPISchema is a JSON schema inferred from samples, the required
+// properties being the ones present in all of them.
+type openAPISchema struct {
+	Type       string                    `json:"type,omitempty"`
+	Format     string                    `json:"format,omitempty"`
+	Nullable   bool                      `json:"nullable,omitempty"`
+	Properties map[string]*openAPISchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Items      *openAPISchema            `json:"items,omitempty"`
+	objects    int
+	seen       map[string]int
+}
+
+func (s *openAPISchema) setType(kind, format string) {
+	switch {
+	case s.Type == "":
+		s.Type, s.Format = kind, format
+	case s.Type == kind:
+		if s.Format != format {
+			s.Format = ""
+		}
+	case (s.Type == "integer" && kind == "number") || (s.Type == "number" && kind == "integer"):
+		s.Type, s.Format = "number", ""
+	default:
+		// Conflicting types are left unconstrained.
+		s.Type, s.Format = "", ""
+		s.Properties, s.Items = nil, nil
+	}
+}
+
+func (s *openAPISchema) add(value interface{}) {
+	switch value := value.(type) {
+	case nil:
+		s.Nullable = true
+	case bool:
+		s.setType("boolean", "")
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			s.setType("integer", "")
+		} else {
+			s.setType("number", "")
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339, value); err == nil {
+			s.setType("string", "date-time")
+		} else {
+			s.setType("string", "")
+		}
+	case []interface{}:
+		s.setType("array", "")
+		if s.Type != "array" {
+			return
+		}
+		if s.Items == nil {
+			s.Items = &openAPISchema{}
+		}
+		for _, item := range value {
+			s.Items.add(item)
+		}
+	case map[string]interface{}:
+		s.setType("object", "")
+		if s.Type != "object" {
+			return
+		}
+		if s.Properties == nil {
+			s.Properties, s.seen = map[string]*openAPISchema{}, map[string]int{}
+		}
+		s.objects++
+		for name, property := range value {
+			if s.Properties[name] == nil {
+				s.Properties[name] = &openAPISchema{}
+			}
+			s.Properties[name].add(property)
+			s.seen[name]++
+		}
+	}
+}
+
+func (s *openAPISchema) finish() {
+	if s == nil {
+		return
+	}
+	s.Required = nil
+	for name, property := range s.Properties {
+		if s.seen[name] == s.objects {
+			s.Required = append(s.Required, name)
+		}
+		property.finish()
+	}
+	sort.Strings(s.Required)
+	s.Items.finish()
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+	seen     int
+}
+
+type openAPIRequestBody struct {
+	Content map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	samples     int
+	query       map[string]*openAPIParameter
+}
+
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers,omitempty"`
+	Paths map[string]map[string]*openAPIOperation `json:"paths"`
+}
+
+// templatePath replaces the identifier segments of a path by parameters.
+func templatePath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	params := []string{}
+	for i, segment := range segments {
+		if !identifierSegment.MatchString(segment) {
+			continue
+		}
+		name := "id"
+		if i > 0 && segments[i-1] != "" && !strings.HasPrefix(segments[i-1], "{") {
+			name = strings.TrimSuffix(segments[i-1], "s") + "Id"
+		}
+		if len(params) > 0 {
+			name += strconv.Itoa(len(params))
+		}
+		params = append(params, name)
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// addContent adds a body sample to the schema of its media type, non JSON
+// bodies being described as strings.
+func addContent(content map[string]*openAPIMediaType, contentType, body string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	if content[mediaType] == nil {
+		content[mediaType] = &openAPIMediaType{Schema: &openAPISchema{}}
+	}
+	if doc, ok := decodeJSON(body); ok && strings.Contains(mediaType, "json") {
+		content[mediaType].Schema.add(doc)
+	} else {
+		content[mediaType].Schema.setType("string", "")
+	}
+}
+
+func inferOpenAPI(exchanges []exchange, title string) openAPIDocument {
+	doc := openAPIDocument{OpenAPI: "3.0.3", Paths: map[string]map[string]*openAPIOperation{}}
+	doc.Info.Title = title
+	doc.Info.Version = "inferred"
+
+	servers := map[string]bool{}
+	for _, ex := range exchanges {
+		request := ex.Request
+		u := recordURL(*request)
+		if server := u.Scheme + "://" + u.Host; !servers[server] {
+			servers[server] = true
+			doc.Servers = append(doc.Servers, struct {
+				URL string `json:"url"`
+			}{server})
+		}
+
+		path, params := templatePath(u.Path)
+		method := strings.ToLower(request.Method)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIOperation{}
+		}
+		op := doc.Paths[path][method]
+		if op == nil {
+			op = &openAPIOperation{
+				Summary:   request.Method + " " + path,
+				Responses: map[string]*openAPIResponse{},
+				query:     map[string]*openAPIParameter{},
+			}
+			for _, name := range params {
+				op.Parameters = append(op.Parameters, &openAPIParameter{Name: name, In: "path", Required: true, Schema: &openAPISchema{Type: "string"}})
+			}
+			doc.Paths[path][method] = op
+		}
+		op.samples++
+
+		query, _ := url.ParseQuery(u.RawQuery)
+		for name, values := range query {
+			param := op.query[name]
+			if param == nil {
+				param = &openAPIParameter{Name: name, In: "query", Schema: &openAPISchema{}}
+				op.query[name] = param
+				op.Parameters = append(op.Parameters, param)
+			}
+			param.seen++
+			for _, value := range values {
+				if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+					param.Schema.setType("integer", "")
+				} else {
+					param.Schema.setType("string", "")
+				}
+			}
+		}
+
+		if request.Body != "" {
+			if op.RequestBody == nil {
+				op.RequestBody = &openAPIRequestBody{Content: map[string]*openAPIMediaType{}}
+			}
+			addContent(op.RequestBody.Content, findHeader(request.Headers, "Content-Type"), request.Body)
+		}
+
+		if response := ex.Response; response != nil {
+			status := strconv.Itoa(response.StatusCode)
+			if op.Responses[status] == nil {
+				op.Responses[status] = &openAPIResponse{Description: strings.TrimSpace(strings.TrimPrefix(response.Status, status))}
+			}
+			if response.Body != "" {
+				if op.Responses[status].Content == nil {
+					op.Responses[status].Content = map[string]*openAPIMediaType{}
+				}
+				addContent(op.Responses[status].Content, findHeader(response.Headers, "Content-Type"), response.Body)
+			}
+		}
+	}
+
+	for _, operations := range doc.Paths {
+		for _, op := range operations {
+			for _, param := range op.query {
+				param.Required = param.seen == op.samples
+			}
+			sort.SliceStable(op.Parameters, func(i, j int) bool {
+				if op.Parameters[i].In != op.Parameters[j].In {
+					return op.Parameters[i].In == "path"
+				}
+				return op.Parameters[i].Name < op.Parameters[j].Name
+			})
+			if op.RequestBody != nil {
+				for _, media := range op.RequestBody.Content {
+					media.Schema.finish()
+				}
+			}
+			for _, response := range op.Responses {
+				if response.Description == "" {
+					response.Description = "Recorded response."
+				}
+				for _, media := range response.Content {
+					media.Schema.finish()
+				}
+			}
+			if len(op.Responses) == 0 {
+				op.Responses["default"] = &openAPIResponse{Description: "No recorded response."}
+			}
+		}
+	}
+	return doc
+}
+
+func inferOpenAPICommand() {
+	inferrer := flag.NewFlagSet("infer-openapi", flag.PanicOnError)
+	dir := inferrer.String("dir", ".", "Directory of the records to infer the specification from.")
+	out := inferrer.String("out", "", "File where the OpenAPI document is written, standard output if empty.")
+	title := inferrer.String("title", "Inferred API", "Title of the OpenAPI document.")
+	inferrer.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  out: %s", *out)
+	log.Printf("  title: %s", *title)
+
+	records, err := loadExportRecords(*dir, "request", "response")
+	if err != nil {
+		log.Fatalf("Error while loading records: %s", err)
+	}
+	exchanges := joinExchanges(records)
+	doc := inferOpenAPI(exchanges, *title)
+
+	var writer io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Error while creating %s: %s", *out, err)
+		}
+		defer f.Close()
+		writer = f
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		log.Fatalf("Error while writing OpenAPI document: %s", err)
+	}
+	log.Printf("Inferred %d path(s) from %d exchange(s).", len(doc.Paths), len(exchanges))
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minPacingDelay       = 100 * time.Millisecond
+	throttledPacingDelay = 500 * time.Millisecond
+)
+
+// adaptivePacer spaces the redone requests by a delay growing when the
+// target throttles (429 or 503) or its latency rises past threshold, and
+// shrinking back to none while it keeps up, pausing for the Retry-After
+// duration it asks for.
+type adaptivePacer struct {
+	threshold time.Duration
+	maxDelay  time.Duration
+	mutex     sync.Mutex
+	delay     time.Duration
+	next      time.Time
+}
+
+func makeAdaptivePacer(enabled bool, threshold, maxDelay time.Duration) *adaptivePacer {
+	if !enabled {
+		return nil
+	}
+	return &adaptivePacer{threshold: threshold, maxDelay: maxDelay}
+}
+
+// reserve returns how long to wait before sending the next request.
+func (ap *adaptivePacer) reserve() time.Duration {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	now := time.Now()
+	if ap.next.Before(now) {
+		ap.next = now
+	}
+	wait := ap.next.Sub(now)
+	ap.next = ap.next.Add(ap.delay)
+	return wait
+}
+
+// observe adapts the delay to the outcome of a request.
+func (ap *adaptivePacer) observe(req *http.Request, resp *http.Response, latency time.Duration) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	previous := ap.delay
+
+	switch {
+	case resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable):
+		ap.slowDown(throttledPacingDelay)
+		if pause, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if pause > ap.maxDelay {
+				pause = ap.maxDelay
+			}
+			if until := time.Now().Add(pause); until.After(ap.next) {
+				ap.next = until
+			}
+			log.Printf("Pacing: %s %s: %s, pausing for %s as asked by Retry-After", req.Method, req.URL, resp.Status, pause)
+		}
+	case ap.threshold > 0 && latency > ap.threshold:
+		ap.slowDown(minPacingDelay)
+	default:
+		if ap.delay /= 2; ap.delay < minPacingDelay/10 {
+			ap.delay = 0
+		}
+	}
+
+	if ap.delay > previous {
+		log.Printf("Pacing: %s %s took %s (%s), slowing down to one request every %s", req.Method, req.URL, latency.Round(time.Millisecond), outcomeStatus(resp), ap.delay)
+	} else if ap.delay == 0 && previous != 0 {
+		log.Print("Pacing: target keeping up, back to full speed")
+	}
+}
+
+func (ap *adaptivePacer) slowDown(minimum time.Duration) {
+	if ap.delay *= 2; ap.delay < minimum {
+		ap.delay = minimum
+	}
+	if ap.delay > ap.maxDelay {
+		ap.delay = ap.maxDelay
+	}
+}
+
+func outcomeStatus(resp *http.Response) string {
+	if resp == nil {
+		return "error"
+	}
+	return resp.Status
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value = strings.TrimSpace(value); value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if pause := date.Sub(now); pause > 0 {
+			return pause, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// wrap returns a transport pacing the requests sent with next.
+func (ap *adaptivePacer) wrap(next http.RoundTripper) http.RoundTripper {
+	if ap == nil {
+		return next
+	}
+	return pacedTransport{pacer: ap, next: next}
+}
+
+type pacedTransport struct {
+	pacer *adaptivePacer
+	next  http.RoundTripper
+}
+
+func (pt pacedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := pt.pacer.reserve(); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	started := time.Now()
+	resp, err := pt.next.RoundTrip(req)
+	pt.pacer.observe(req, resp, time.Since(started))
+	return resp, err
+}
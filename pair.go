@@ -0,0 +1,102 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type pendingPairKey struct{}
+
+// pendingPair holds the response of a proxied request until the request is
+// recorded along with it.
+type pendingPair struct {
+	response         *responseRecord
+	responseReceived time.Time
+}
+
+func pendingPairOf(r *http.Request) *pendingPair {
+	if r == nil {
+		return nil
+	}
+	pair, _ := r.Context().Value(pendingPairKey{}).(*pendingPair)
+	return pair
+}
+
+type pairTiming struct {
+	RequestReceived  time.Time
+	RequestForwarded time.Time
+	ResponseReceived time.Time
+	Duration         time.Duration
+}
+
+// pairRecord is a request and its response, saved with --pair-records.
+type pairRecord struct {
+	ID            string
+	Date, DateUTC time.Time
+	DateUnixNano  int64
+	Timing        pairTiming
+	Request       requestRecord
+	Response      *responseRecord `json:",omitempty"`
+}
+
+func (ghr goHRec) savePair(req string, record requestRecord, rt recordingTime, body io.Reader, pending *pendingPair) {
+	ghr.completeRequest(req, &record, rt, body)
+
+	pair := pairRecord{
+		ID:           record.ID,
+		Date:         rt.requestReceived,
+		DateUTC:      rt.requestReceived.UTC(),
+		DateUnixNano: rt.requestReceived.UnixNano(),
+		Timing: pairTiming{
+			RequestReceived:  rt.requestReceived.UTC(),
+			RequestForwarded: rt.requestForwarded.UTC(),
+		},
+		Request:  record,
+		Response: pending.response,
+	}
+	if pending.response != nil {
+		pair.Timing.ResponseReceived = pending.responseReceived.UTC()
+		pair.Timing.Duration = pending.responseReceived.Sub(rt.requestReceived)
+	}
+
+	json, err := json.MarshalIndent(pair, "", " ")
+	if err != nil {
+		ghr.logError(errorStorage, "Error while serializing record", err, "request", req)
+		return
+	}
+
+	secrets := record.Secrets
+	if pair.Response != nil {
+		secrets = append(secrets, pair.Response.Secrets...)
+	}
+	filename, err := ghr.quarantined(secrets, req).saveJSON(json, pair.ID, rt.requestReceived, "pair", req)
+	if err == nil {
+		notif := notification{ID: pair.ID, Kind: "pair", Filename: filename, Method: record.Method, Path: record.Path}
+		if pair.Response != nil {
+			notif.StatusCode = pair.Response.StatusCode
+		}
+		ghr.notifier.notify(notif)
+	}
+	ghr.log(slog.LevelInfo, "Recorded", "file", filename, "request", req, "id", pair.ID, "path", record.Path, "duration", pair.Timing.Duration)
+}
+
+// recordOfPair returns the JSON of the request or response of a pair record.
+func recordOfPair(content []byte, kind string) ([]byte, error) {
+	var pair struct {
+		Request, Response json.RawMessage
+	}
+	if err := json.Unmarshal(content, &pair); err != nil {
+		return nil, err
+	}
+	if kind == "response" {
+		return pair.Response, nil
+	}
+	return pair.Request, nil
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	parquetMagic        = "PAR1"
+	parquetRowGroupSize = 10000
+
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetRepeated = 2
+
+	parquetUTF8            = 0
+	parquetList            = 3
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+	parquetGzip  = 2
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, used by the
+// metadata and page headers of Parquet files.
+type thriftWriter struct {
+	bytes.Buffer
+	lastFields []int16
+	lastField  int16
+}
+
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (tw *thriftWriter) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	tw.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (tw *thriftWriter) zigzag(v int64) {
+	tw.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (tw *thriftWriter) field(id int16, kind byte) {
+	if delta := id - tw.lastField; delta > 0 && delta <= 15 {
+		tw.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		tw.WriteByte(kind)
+		tw.zigzag(int64(id))
+	}
+	tw.lastField = id
+}
+
+func (tw *thriftWriter) i32(id int16, v int32) {
+	tw.field(id, thriftI32)
+	tw.zigzag(int64(v))
+}
+
+func (tw *thriftWriter) i64(id int16, v int64) {
+	tw.field(id, thriftI64)
+	tw.zigzag(v)
+}
+
+func (tw *thriftWriter) binary(v string) {
+	tw.varint(uint64(len(v)))
+	tw.WriteString(v)
+}
+
+func (tw *thriftWriter) string(id int16, v string) {
+	tw.field(id, thriftBinary)
+	tw.binary(v)
+}
+
+func (tw *thriftWriter) list(id int16, kind byte, size int) {
+	tw.field(id, thriftList)
+	if size < 15 {
+		tw.WriteByte(byte(size)<<4 | kind)
+	} else {
+		tw.WriteByte(0xf0 | kind)
+		tw.varint(uint64(size))
+	}
+}
+
+func (tw *thriftWriter) begin() {
+	tw.lastFields = append(tw.lastFields, tw.lastField)
+	tw.lastField = 0
+}
+
+func (tw *thriftWriter) structField(id int16) {
+	tw.field(id, thriftStruct)
+	tw.begin()
+}
+
+func (tw *thriftWriter) end() {
+	tw.WriteByte(0)
+	tw.lastField = tw.lastFields[len(tw.lastFields)-1]
+	tw.lastFields = tw.lastFields[:len(tw.lastFields)-1]
+}
+
+// parquetColumn is a column of a Parquet file, either a flat required one or,
+// if list is set, a list of required strings.
+type parquetColumn struct {
+	name          string
+	kind          int32
+	convertedType int32
+	list          bool
+	value         func(record exportRecord) interface{}
+}
+
+var parquetColumns = []parquetColumn{
+	{"kind", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.kind }},
+	{"id", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.ID }},
+	{"date", parquetInt64, parquetTimestampMillis, false, func(r exportRecord) interface{} { return r.DateUnixNano / 1e6 }},
+	{"protocol", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.Protocol }},
+	{"remote_addr", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.RemoteAddr }},
+	{"method", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.Method }},
+	{"host", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.Host }},
+	{"path", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.Path }},
+	{"uri", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.URI }},
+	{"query", parquetByteArray, parquetUTF8, true, func(r exportRecord) interface{} { return r.Query }},
+	{"headers", parquetByteArray, parquetUTF8, true, func(r exportRecord) interface{} { return r.Headers }},
+	{"status_code", parquetInt32, -1, false, func(r exportRecord) interface{} { return int32(r.StatusCode) }},
+	{"content_length", parquetInt64, -1, false, func(r exportRecord) interface{} { return r.ContentLength }},
+	{"body", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.Body }},
+	{"session_id", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.SessionID }},
+	{"correlation_id", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.CorrelationID }},
+	{"file", parquetByteArray, parquetUTF8, false, func(r exportRecord) interface{} { return r.file }},
+}
+
+// parquetLevels encodes levels of bit width 1 as a length prefixed sequence
+// of RLE runs.
+func parquetLevels(levels []byte) []byte {
+	var runs thriftWriter
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		runs.varint(uint64(j-i) << 1)
+		runs.WriteByte(levels[i])
+		i = j
+	}
+	out := make([]byte, 4, 4+runs.Len())
+	binary.LittleEndian.PutUint32(out, uint32(runs.Len()))
+	return append(out, runs.Bytes()...)
+}
+
+// parquetPage encodes the values of a column in a plain data page, returning
+// it with its number of values, repetition levels included.
+func parquetPage(column parquetColumn, records []exportRecord) ([]byte, int) {
+	var values bytes.Buffer
+	plain := func(value interface{}) {
+		switch value := value.(type) {
+		case string:
+			binary.Write(&values, binary.LittleEndian, uint32(len(value)))
+			values.WriteString(value)
+		default:
+			binary.Write(&values, binary.LittleEndian, value)
+		}
+	}
+	if !column.list {
+		for _, record := range records {
+			plain(column.value(record))
+		}
+		return values.Bytes(), len(records)
+	}
+	repetitions, definitions := []byte{}, []byte{}
+	for _, record := range records {
+		items := column.value(record).([]string)
+		if len(items) == 0 {
+			repetitions, definitions = append(repetitions, 0), append(definitions, 0)
+		}
+		for i, item := range items {
+			repetition := byte(1)
+			if i == 0 {
+				repetition = 0
+			}
+			repetitions, definitions = append(repetitions, repetition), append(definitions, 1)
+			plain(item)
+		}
+	}
+	page := append(parquetLevels(repetitions), parquetLevels(definitions)...)
+	return append(page, values.Bytes()...), len(repetitions)
+}
+
+// writeParquetSchema writes the schema elements of the columns, lists using
+// the standard 3-level representation.
+func writeParquetSchema(tw *thriftWriter) {
+	elements := 1
+	for _, column := range parquetColumns {
+		if column.list {
+			elements += 3
+		} else {
+			elements++
+		}
+	}
+	tw.list(2, thriftStruct, elements)
+	element := func(name string, kind, repetition, children, convertedType int32) {
+		tw.begin()
+		if kind >= 0 {
+			tw.i32(1, kind)
+		}
+		if repetition >= 0 {
+			tw.i32(3, repetition)
+		}
+		tw.string(4, name)
+		if children > 0 {
+			tw.i32(5, children)
+		}
+		if convertedType >= 0 {
+			tw.i32(6, convertedType)
+		}
+		tw.end()
+	}
+	element("gohrec", -1, -1, int32(len(parquetColumns)), -1)
+	for _, column := range parquetColumns {
+		if column.list {
+			element(column.name, -1, parquetRequired, 1, parquetList)
+			element("list", -1, parquetRepeated, 1, -1)
+			element("element", column.kind, parquetRequired, 0, column.convertedType)
+		} else {
+			element(column.name, column.kind, parquetRequired, 0, column.convertedType)
+		}
+	}
+}
+
+// exportParquet writes records as a Parquet file of gzip compressed column
+// chunks, in row groups of parquetRowGroupSize records.
+func exportParquet(records []exportRecord, out io.Writer) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1)
+	writeParquetSchema(&meta)
+	meta.i64(3, int64(len(records)))
+	groups := (len(records) + parquetRowGroupSize - 1) / parquetRowGroupSize
+	meta.list(4, thriftStruct, groups)
+	for start := 0; start < len(records); start += parquetRowGroupSize {
+		group := records[start:]
+		if len(group) > parquetRowGroupSize {
+			group = group[:parquetRowGroupSize]
+		}
+		meta.begin()
+		meta.list(1, thriftStruct, len(parquetColumns))
+		groupStart := file.Len()
+		for _, column := range parquetColumns {
+			page, count := parquetPage(column, group)
+			var compressed bytes.Buffer
+			gz := gzip.NewWriter(&compressed)
+			gz.Write(page)
+			if err := gz.Close(); err != nil {
+				return err
+			}
+
+			var header thriftWriter
+			header.begin()
+			header.i32(1, 0)
+			header.i32(2, int32(len(page)))
+			header.i32(3, int32(compressed.Len()))
+			header.structField(5)
+			header.i32(1, int32(count))
+			header.i32(2, parquetPlain)
+			header.i32(3, parquetRLE)
+			header.i32(4, parquetRLE)
+			header.end()
+			header.end()
+
+			offset := int64(file.Len())
+			file.Write(header.Bytes())
+			file.Write(compressed.Bytes())
+
+			path := []string{column.name}
+			if column.list {
+				path = append(path, "list", "element")
+			}
+			meta.begin()
+			meta.i64(2, offset)
+			meta.structField(3)
+			meta.i32(1, column.kind)
+			meta.list(2, thriftI32, 2)
+			meta.zigzag(parquetPlain)
+			meta.zigzag(parquetRLE)
+			meta.list(3, thriftBinary, len(path))
+			for _, name := range path {
+				meta.binary(name)
+			}
+			meta.i32(4, parquetGzip)
+			meta.i64(5, int64(count))
+			meta.i64(6, int64(header.Len()+len(page)))
+			meta.i64(7, int64(header.Len()+compressed.Len()))
+			meta.i64(9, offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, int64(file.Len()-groupStart))
+		meta.i64(3, int64(len(group)))
+		meta.end()
+	}
+	meta.string(6, "gohrec")
+	meta.end()
+
+	file.Write(meta.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.Len()))
+	file.WriteString(parquetMagic)
+	_, err := out.Write(file.Bytes())
+	return err
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pgTimeout = 10 * time.Second
+
+var pgIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pgStore is a minimal PostgreSQL client inserting records into a table,
+// with the whole record and its JSON body as JSONB and indexed columns to
+// query them.
+type pgStore struct {
+	url   *url.URL
+	table string
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+func makePGStore(raw string) (*pgStore, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --store `%s`: %s", redactedURL(raw), err)
+	}
+	query := u.Query()
+	ps := &pgStore{url: u, table: query.Get("table")}
+	if ps.table == "" {
+		ps.table = "gohrec_records"
+	}
+	if !pgIdentifier.MatchString(ps.table) {
+		return nil, fmt.Errorf("Invalid --store table `%s`.", ps.table)
+	}
+	switch query.Get("sslmode") {
+	case "", "disable", "require", "verify-full":
+	default:
+		return nil, fmt.Errorf("Unsupported --store sslmode `%s`, expected `disable`, `require` or `verify-full`.", query.Get("sslmode"))
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if err := ps.connect(); err != nil {
+		return nil, fmt.Errorf("Error while connecting to PostgreSQL: %s", err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS %[1]s (id text NOT NULL, kind text NOT NULL, name text NOT NULL, date timestamptz NOT NULL, method text, path text, status integer, record jsonb NOT NULL, body jsonb, PRIMARY KEY (id, kind))`,
+		`CREATE INDEX IF NOT EXISTS %[1]s_date_idx ON %[1]s (date)`,
+		`CREATE INDEX IF NOT EXISTS %[1]s_path_idx ON %[1]s (path)`,
+		`CREATE INDEX IF NOT EXISTS %[1]s_method_idx ON %[1]s (method)`,
+		`CREATE INDEX IF NOT EXISTS %[1]s_status_idx ON %[1]s (status)`,
+	} {
+		if err := ps.exec(fmt.Sprintf(statement, ps.table)); err != nil {
+			return nil, fmt.Errorf("Error while creating PostgreSQL table: %s", err)
+		}
+	}
+	return ps, nil
+}
+
+// pgMessage encodes a frontend message, typ being 0 for untyped ones.
+func pgMessage(typ byte, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	message := []byte{}
+	if typ != 0 {
+		message = append(message, typ)
+	}
+	message = binary.BigEndian.AppendUint32(message, uint32(len(body)+4))
+	return append(message, body...)
+}
+
+func pgString(value string) []byte {
+	return append([]byte(value), 0)
+}
+
+func pgInt16(value int16) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(value))
+}
+
+func pgInt32(value int32) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(value))
+}
+
+// receive reads a backend message.
+func (ps *pgStore) receive() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(ps.conn, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	if _, err := io.ReadFull(ps.conn, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// pgError formats the message fields of an ErrorResponse.
+func pgError(body []byte) error {
+	fields := map[byte]string{}
+	for len(body) > 1 {
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			break
+		}
+		fields[body[0]] = string(body[1 : end+1])
+		body = body[end+2:]
+	}
+	return fmt.Errorf("%s: %s (%s)", fields['S'], fields['M'], fields['C'])
+}
+
+func (ps *pgStore) connect() (err error) {
+	defer func() {
+		if err != nil && ps.conn != nil {
+			ps.conn.Close()
+			ps.conn = nil
+		}
+	}()
+
+	host := ps.url.Hostname()
+	port := ps.url.Port()
+	if port == "" {
+		port = "5432"
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), pgTimeout)
+	if err != nil {
+		return err
+	}
+	ps.conn = conn
+	conn.SetDeadline(time.Now().Add(pgTimeout))
+
+	if sslmode := ps.url.Query().Get("sslmode"); sslmode == "require" || sslmode == "verify-full" {
+		if _, err := conn.Write(pgMessage(0, pgInt32(80877103))); err != nil {
+			return err
+		}
+		answer := make([]byte, 1)
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return err
+		}
+		if answer[0] != 'S' {
+			return fmt.Errorf("server does not support SSL")
+		}
+		ps.conn = tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: sslmode == "require"})
+	}
+
+	user := ps.url.User.Username()
+	password, _ := ps.url.User.Password()
+	database := strings.TrimPrefix(ps.url.Path, "/")
+	if database == "" {
+		database = user
+	}
+	startup := pgMessage(0, pgInt32(196608), pgString("user"), pgString(user), pgString("database"), pgString(database),
+		pgString("application_name"), pgString("gohrec"), []byte{0})
+	if _, err := ps.conn.Write(startup); err != nil {
+		return err
+	}
+
+	var scram *pgSCRAM
+	for {
+		typ, body, err := ps.receive()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'E':
+			return pgError(body)
+		case 'Z':
+			ps.conn.SetDeadline(time.Time{})
+			return nil
+		case 'R':
+			var response []byte
+			switch code := binary.BigEndian.Uint32(body); code {
+			case 0:
+				continue
+			case 3:
+				response = pgMessage('p', pgString(password))
+			case 5:
+				inner := md5.Sum([]byte(password + user))
+				outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), body[4:8]...))
+				response = pgMessage('p', pgString("md5"+hex.EncodeToString(outer[:])))
+			case 10:
+				if !bytes.Contains(body[4:], []byte("SCRAM-SHA-256\x00")) {
+					return fmt.Errorf("unsupported SASL mechanisms")
+				}
+				scram = makePGSCRAM(password)
+				first := []byte(scram.clientFirst())
+				response = pgMessage('p', pgString("SCRAM-SHA-256"), pgInt32(int32(len(first))), first)
+			case 11:
+				if scram == nil {
+					return fmt.Errorf("unexpected SASL continue")
+				}
+				final, err := scram.clientFinal(string(body[4:]))
+				if err != nil {
+					return err
+				}
+				response = pgMessage('p', []byte(final))
+			case 12:
+				if scram == nil || !scram.verify(string(body[4:])) {
+					return fmt.Errorf("invalid SCRAM server signature")
+				}
+				continue
+			default:
+				return fmt.Errorf("unsupported authentication method %d", code)
+			}
+			if _, err := ps.conn.Write(response); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pgSCRAM is the client side of a SCRAM-SHA-256 authentication.
+type pgSCRAM struct {
+	password, nonce string
+	clientFirstBare string
+	authMessage     string
+	saltedPassword  []byte
+}
+
+func makePGSCRAM(password string) *pgSCRAM {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	return &pgSCRAM{password: password, nonce: base64.StdEncoding.EncodeToString(nonce)}
+}
+
+func (s *pgSCRAM) clientFirst() string {
+	s.clientFirstBare = "n=,r=" + s.nonce
+	return "n,," + s.clientFirstBare
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func (s *pgSCRAM) clientFinal(serverFirst string) (string, error) {
+	attributes := map[string]string{}
+	for _, attribute := range strings.Split(serverFirst, ",") {
+		if len(attribute) > 2 && attribute[1] == '=' {
+			attributes[attribute[:1]] = attribute[2:]
+		}
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	if err != nil {
+		return "", err
+	}
+	iterations, err := strconv.Atoi(attributes["i"])
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(attributes["r"], s.nonce) {
+		return "", fmt.Errorf("invalid SCRAM server nonce")
+	}
+
+	if s.saltedPassword, err = pbkdf2.Key(sha256.New, s.password, salt, iterations, sha256.Size); err != nil {
+		return "", err
+	}
+	clientKey := scramHMAC(s.saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + attributes["r"]
+	s.authMessage = s.clientFirstBare + "," + serverFirst + "," + withoutProof
+	signature := scramHMAC(storedKey[:], s.authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= signature[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientKey), nil
+}
+
+func (s *pgSCRAM) verify(serverFinal string) bool {
+	expected := scramHMAC(scramHMAC(s.saltedPassword, "Server Key"), s.authMessage)
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(serverFinal, "v="))
+	return err == nil && hmac.Equal(signature, expected)
+}
+
+// wait reads messages until the server is ready for a query, returning the
+// first error it reported.
+func (ps *pgStore) wait() error {
+	var queryErr error
+	for {
+		typ, body, err := ps.receive()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'E':
+			if queryErr == nil {
+				queryErr = pgError(body)
+			}
+		case 'Z':
+			return queryErr
+		}
+	}
+}
+
+// exec runs a statement without parameters.
+func (ps *pgStore) exec(statement string) error {
+	ps.conn.SetDeadline(time.Now().Add(pgTimeout))
+	if _, err := ps.conn.Write(pgMessage('Q', pgString(statement))); err != nil {
+		return err
+	}
+	return ps.wait()
+}
+
+// execParams runs a statement with text parameters, nil ones being NULL.
+func (ps *pgStore) execParams(statement string, params ...*string) error {
+	bind := [][]byte{pgString(""), pgString(""), pgInt16(0), pgInt16(int16(len(params)))}
+	for _, param := range params {
+		if param == nil {
+			bind = append(bind, pgInt32(-1))
+			continue
+		}
+		bind = append(bind, pgInt32(int32(len(*param))), []byte(*param))
+	}
+	bind = append(bind, pgInt16(0))
+
+	var messages bytes.Buffer
+	messages.Write(pgMessage('P', pgString(""), pgString(statement), pgInt16(0)))
+	messages.Write(pgMessage('B', bind...))
+	messages.Write(pgMessage('E', pgString(""), pgInt32(0)))
+	messages.Write(pgMessage('S'))
+
+	ps.conn.SetDeadline(time.Now().Add(pgTimeout))
+	if _, err := ps.conn.Write(messages.Bytes()); err != nil {
+		return err
+	}
+	return ps.wait()
+}
+
+// pgColumns returns the indexed columns of a record, and its body when it is
+// JSON.
+func pgColumns(record sinkRecord) (method, path, status, body *string) {
+	type info struct {
+		Method, Path       string
+		StatusCode         int
+		Body, BodyEncoding string
+	}
+	var fields struct {
+		info
+		Request  *info
+		Response *info
+	}
+	if err := json.Unmarshal(record.Content, &fields); err != nil {
+		return
+	}
+	request, response := fields.info, fields.info
+	if fields.Request != nil {
+		request = *fields.Request
+	}
+	if fields.Response != nil {
+		response = *fields.Response
+	}
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	method, path = optional(request.Method), optional(request.Path)
+	if response.StatusCode != 0 {
+		status = optional(strconv.Itoa(response.StatusCode))
+	}
+	if fields.info.BodyEncoding == "" && json.Valid([]byte(fields.info.Body)) {
+		body = optional(fields.info.Body)
+	} else if fields.Request != nil && request.BodyEncoding == "" && json.Valid([]byte(request.Body)) {
+		body = optional(request.Body)
+	}
+	return
+}
+
+func (ps *pgStore) insert(record sinkRecord) error {
+	if ps.conn == nil {
+		if err := ps.connect(); err != nil {
+			return err
+		}
+	}
+	method, path, status, body := pgColumns(record)
+	date, content := record.Date.UTC().Format(time.RFC3339Nano), string(record.Content)
+	err := ps.execParams(fmt.Sprintf(`INSERT INTO %s (id, kind, name, date, method, path, status, record, body) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (id, kind) DO NOTHING`, ps.table),
+		&record.ID, &record.Kind, &record.Name, &date, method, path, status, &content, body)
+	if _, ok := err.(net.Error); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+		ps.conn.Close()
+		ps.conn = nil
+	}
+	return err
+}
+
+// publish inserts a record, reconnecting and retrying once when the
+// connection was lost.
+func (ps *pgStore) publish(record sinkRecord) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	err := ps.insert(record)
+	if err != nil && ps.conn == nil {
+		err = ps.insert(record)
+	}
+	return err
+}
+
+func (ps *pgStore) close() error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.conn == nil {
+		return nil
+	}
+	ps.conn.Write(pgMessage('X'))
+	err := ps.conn.Close()
+	ps.conn = nil
+	return err
+}
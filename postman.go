@@ -0,0 +1,149 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+)
+
+type postmanKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanURL struct {
+	Raw      string            `json:"raw"`
+	Protocol string            `json:"protocol,omitempty"`
+	Host     []string          `json:"host,omitempty"`
+	Port     string            `json:"port,omitempty"`
+	Path     []string          `json:"path,omitempty"`
+	Query    []postmanKeyValue `json:"query,omitempty"`
+}
+
+type postmanBody struct {
+	Mode string `json:"mode"`
+	Raw  string `json:"raw"`
+}
+
+type postmanRequest struct {
+	Method string            `json:"method"`
+	Header []postmanKeyValue `json:"header"`
+	Body   *postmanBody      `json:"body,omitempty"`
+	URL    postmanURL        `json:"url"`
+}
+
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []*postmanItem  `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+}
+
+type postmanCollection struct {
+	Info struct {
+		PostmanID string `json:"_postman_id"`
+		Name      string `json:"name"`
+		Schema    string `json:"schema"`
+	} `json:"info"`
+	Item []*postmanItem `json:"item"`
+}
+
+// postmanSkippedHeaders are computed by Postman or only relevant to gohrec.
+var postmanSkippedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Host":              true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+func recordURL(record exportRecord) *url.URL {
+	if u, err := url.Parse(record.URI); err == nil && u.IsAbs() {
+		return u
+	}
+	u, err := url.Parse("http://" + record.Host + record.URI)
+	if err != nil {
+		return &url.URL{Scheme: "http", Host: record.Host, Path: record.Path}
+	}
+	return u
+}
+
+func postmanRequestOf(record exportRecord) *postmanRequest {
+	u := recordURL(record)
+	request := &postmanRequest{
+		Method: record.Method,
+		Header: []postmanKeyValue{},
+		URL: postmanURL{
+			Raw:      u.String(),
+			Protocol: u.Scheme,
+			Host:     strings.Split(u.Hostname(), "."),
+			Port:     u.Port(),
+		},
+	}
+	if path := strings.Trim(u.EscapedPath(), "/"); path != "" {
+		request.URL.Path = strings.Split(path, "/")
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		split := strings.SplitN(pair, "=", 2)
+		kv := postmanKeyValue{Key: split[0]}
+		if len(split) == 2 {
+			kv.Value = split[1]
+		}
+		request.URL.Query = append(request.URL.Query, kv)
+	}
+	for _, header := range record.Headers {
+		split := strings.SplitN(header, ": ", 2)
+		if len(split) != 2 || postmanSkippedHeaders[split[0]] || strings.HasPrefix(split[0], "X-Gohrec-") {
+			continue
+		}
+		request.Header = append(request.Header, postmanKeyValue{Key: split[0], Value: split[1]})
+	}
+	if record.Body != "" {
+		request.Body = &postmanBody{Mode: "raw", Raw: record.Body}
+	}
+	return request
+}
+
+// exportPostman writes request records as a Postman v2.1 collection, with a
+// folder per host holding a folder per path.
+func exportPostman(records []exportRecord, out io.Writer, name string) error {
+	collection := postmanCollection{}
+	collection.Info.PostmanID = makeUUID()
+	collection.Info.Name = name
+	collection.Info.Schema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+	collection.Item = []*postmanItem{}
+
+	hosts := map[string]*postmanItem{}
+	paths := map[string]*postmanItem{}
+	for _, record := range records {
+		request := postmanRequestOf(record)
+		u := recordURL(record)
+
+		host, ok := hosts[u.Host]
+		if !ok {
+			host = &postmanItem{Name: u.Host}
+			hosts[u.Host] = host
+			collection.Item = append(collection.Item, host)
+		}
+		path, ok := paths[u.Host+u.Path]
+		if !ok {
+			path = &postmanItem{Name: u.Path}
+			if path.Name == "" {
+				path.Name = "/"
+			}
+			paths[u.Host+u.Path] = path
+			host.Item = append(host.Item, path)
+		}
+		path.Item = append(path.Item, &postmanItem{Name: record.Method + " " + u.RequestURI(), Request: request})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(collection)
+}
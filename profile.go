@@ -0,0 +1,78 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profile is a preset of options of a subcommand for a common scenario.
+type profile struct {
+	subcommand string
+	args       []string
+}
+
+var profiles = map[string]profile{
+	"webhook-catcher": {"record", []string{
+		"--respond-status=200",
+		"--index",
+		"--record-malformed",
+		"--record-skips=summary",
+		"--challenge=query:hub.challenge",
+		"--challenge=json:challenge",
+		"--retry-window=1h",
+	}},
+	"api-proxy": {"record", []string{
+		"--proxy",
+		"--pair-records",
+		"--index",
+		"--compress=gzip",
+		"--redact-header-name=Authorization,Cookie,Set-Cookie,Proxy-Authorization",
+		"--metrics",
+	}},
+	"mock-server": {"serve", []string{
+		"--cache-headers",
+		"--verbose",
+	}},
+}
+
+// expandProfile returns the arguments of a subcommand preceded by the options
+// of the profile they select with --profile, if any, so that the options set
+// explicitly override the ones of the profile.
+func expandProfile(subcommand string, args []string) ([]string, error) {
+	name := ""
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		flag := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") || (flag != "profile" && !strings.HasPrefix(flag, "profile=")) {
+			continue
+		}
+		if split := strings.SplitN(flag, "=", 2); len(split) == 2 {
+			name = split[1]
+		} else if i+1 < len(args) {
+			name = args[i+1]
+		}
+	}
+	if name == "" {
+		return args, nil
+	}
+
+	p, ok := profiles[name]
+	if !ok || p.subcommand != subcommand {
+		names := []string{}
+		for name, p := range profiles {
+			if p.subcommand == subcommand {
+				names = append(names, "`"+name+"`")
+			}
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown --profile `%s`, expected %s.", name, strings.Join(names, " or "))
+	}
+	return append(append([]string{}, p.args...), args...), nil
+}
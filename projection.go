@@ -0,0 +1,127 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// bodyProjection describes a body of which only allowlisted JSON fields are
+// kept, the hash being the one of the full body.
+type bodyProjection struct {
+	Size   int64
+	SHA256 string
+}
+
+// parseJSONPaths parses a comma-separated list of JSON paths.
+func parseJSONPaths(spec string) ([]jsonPath, error) {
+	paths := []jsonPath{}
+	for _, raw := range strings.Split(spec, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		path, err := parseJSONPath(raw)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// jsonPathProject returns the parts of a node matched by the path segments,
+// objects and arrays leading to them being kept, array items not matched
+// being null.
+func jsonPathProject(node interface{}, segments []string) (interface{}, bool) {
+	if len(segments) == 0 {
+		return node, true
+	}
+	segment, rest := segments[0], segments[1:]
+	switch value := node.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for key, child := range value {
+			if segment != "*" && key != segment {
+				continue
+			}
+			if projected, ok := jsonPathProject(child, rest); ok {
+				out[key] = projected
+			}
+		}
+		return out, len(out) > 0
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		found := false
+		for i, child := range value {
+			if segment != "*" && segment != strconv.Itoa(i) {
+				continue
+			}
+			if projected, ok := jsonPathProject(child, rest); ok {
+				out[i], found = projected, true
+			}
+		}
+		return out, found
+	}
+	return nil, false
+}
+
+// mergeJSON merges two projections of the same document.
+func mergeJSON(a, b interface{}) interface{} {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			for key, value := range b {
+				if current, ok := a[key]; ok {
+					a[key] = mergeJSON(current, value)
+				} else {
+					a[key] = value
+				}
+			}
+			return a
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok && len(a) == len(b) {
+			for i := range a {
+				if a[i] == nil {
+					a[i] = b[i]
+				} else if b[i] != nil {
+					a[i] = mergeJSON(a[i], b[i])
+				}
+			}
+			return a
+		}
+	case nil:
+		return b
+	}
+	return a
+}
+
+// projectBody keeps only the fields of a JSON body matched by --body-keep-json
+// paths, other bodies being omitted.
+func (ghr goHRec) projectBody(record *baseInfo, content []byte) []byte {
+	if len(ghr.bodyKeepJSON) == 0 || len(content) == 0 {
+		return content
+	}
+	hash := sha256.Sum256(content)
+	record.BodyProjected = &bodyProjection{Size: int64(len(content)), SHA256: hex.EncodeToString(hash[:])}
+	doc, ok := decodeJSON(string(content))
+	if !ok {
+		record.BodyOmitted = true
+		return nil
+	}
+	var projection interface{}
+	for _, path := range ghr.bodyKeepJSON {
+		if projected, ok := jsonPathProject(doc, path.segments); ok {
+			projection = mergeJSON(projection, projected)
+		}
+	}
+	if projection == nil {
+		projection = map[string]interface{}{}
+	}
+	return []byte(encodeJSON(projection))
+}
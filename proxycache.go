@@ -0,0 +1,282 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+type cacheEntry struct {
+	key        string
+	status     string
+	statusCode int
+	proto      string
+	header     http.Header
+	body       []byte
+	stored     time.Time
+	expires    time.Time
+}
+
+func (ce *cacheEntry) size() int64 {
+	size := int64(len(ce.key) + len(ce.body))
+	for name, values := range ce.header {
+		for _, value := range values {
+			size += int64(len(name) + len(value))
+		}
+	}
+	return size
+}
+
+// responseCache is a shared HTTP cache of upstream responses, bounded in size
+// with least recently used entries evicted first, and in freshness.
+type responseCache struct {
+	next    http.RoundTripper
+	maxSize int64
+	maxTTL  time.Duration
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func makeResponseCache(next http.RoundTripper, maxSize int64, maxTTL time.Duration) *responseCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &responseCache{
+		next:    next,
+		maxSize: maxSize,
+		maxTTL:  maxTTL,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			split := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+			if split[0] == "" {
+				continue
+			}
+			if len(split) == 2 {
+				directives[strings.ToLower(split[0])] = strings.Trim(split[1], `"`)
+			} else {
+				directives[strings.ToLower(split[0])] = ""
+			}
+		}
+	}
+	return directives
+}
+
+// cacheKey identifies a request and the values of the headers its cached
+// response varies on.
+func cacheKey(r *http.Request, vary []string) string {
+	key := r.Method + " " + r.URL.String()
+	for _, name := range vary {
+		key += "\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(r.Header.Values(name), ",")
+	}
+	return key
+}
+
+func varyHeaders(header http.Header) []string {
+	var vary []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, name)
+			}
+		}
+	}
+	return vary
+}
+
+// freshness returns how long a response can be served from the cache, zero
+// if it must not be stored.
+func (rc *responseCache) freshness(r *http.Request, resp *http.Response) time.Duration {
+	if !cacheableStatus[resp.StatusCode] {
+		return 0
+	}
+	directives := parseCacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok {
+		return 0
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	if _, ok := directives["private"]; ok {
+		return 0
+	}
+	// Responses setting cookies, and responses to requests carrying
+	// credentials unless explicitly public, are personal and must not be
+	// shared (RFC 7234 §3.2).
+	if len(resp.Header.Values("Set-Cookie")) > 0 {
+		return 0
+	}
+	if _, public := directives["public"]; !public && (r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "") {
+		return 0
+	}
+	for _, vary := range varyHeaders(resp.Header) {
+		if vary == "*" {
+			return 0
+		}
+	}
+
+	var ttl time.Duration
+	if value, ok := directives["s-maxage"]; ok {
+		seconds, _ := strconv.ParseInt(value, 10, 64)
+		ttl = time.Duration(seconds) * time.Second
+	} else if value, ok := directives["max-age"]; ok {
+		seconds, _ := strconv.ParseInt(value, 10, 64)
+		ttl = time.Duration(seconds) * time.Second
+	} else if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		ttl = expires.Sub(date)
+	}
+	if age, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil {
+		ttl -= time.Duration(age) * time.Second
+	}
+	if rc.maxTTL > 0 && ttl > rc.maxTTL {
+		ttl = rc.maxTTL
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+func (rc *responseCache) lookup(r *http.Request) *cacheEntry {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	// The Vary headers of a response are only known once stored, so they are
+	// looked up under the key of the request alone first.
+	element, ok := rc.entries[cacheKey(r, nil)]
+	if !ok {
+		return nil
+	}
+	if vary := varyHeaders(element.Value.(*cacheEntry).header); len(vary) > 0 {
+		if element, ok = rc.entries[cacheKey(r, vary)]; !ok {
+			return nil
+		}
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		rc.remove(element)
+		return nil
+	}
+	rc.lru.MoveToFront(element)
+	return entry
+}
+
+func (rc *responseCache) remove(element *list.Element) {
+	entry := rc.lru.Remove(element).(*cacheEntry)
+	delete(rc.entries, entry.key)
+	rc.size -= entry.size()
+}
+
+func (rc *responseCache) store(r *http.Request, entry *cacheEntry) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	keys := []string{cacheKey(r, nil)}
+	if vary := varyHeaders(entry.header); len(vary) > 0 {
+		keys = append(keys, cacheKey(r, vary))
+	}
+	for _, key := range keys {
+		if element, ok := rc.entries[key]; ok {
+			rc.remove(element)
+		}
+		stored := *entry
+		stored.key = key
+		if stored.size() > rc.maxSize {
+			return
+		}
+		rc.entries[key] = rc.lru.PushFront(&stored)
+		rc.size += stored.size()
+	}
+	for rc.size > rc.maxSize {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// RoundTrip serves fresh cached responses of GET and HEAD requests, and
+// stores cacheable ones received from the upstream.
+func (rc *responseCache) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return rc.next.RoundTrip(r)
+	}
+
+	directives := parseCacheControl(r.Header)
+	_, noStore := directives["no-store"]
+	_, noCache := directives["no-cache"]
+	if !noStore && !noCache && directives["max-age"] != "0" && r.Header.Get("Pragma") != "no-cache" {
+		if entry := rc.lookup(r); entry != nil {
+			metrics.Add("cache_hits", 1)
+			header := entry.header.Clone()
+			header.Set("Age", strconv.FormatInt(int64(time.Since(entry.stored)/time.Second), 10))
+			header.Set("X-Gohrec-Cache", "HIT")
+			return &http.Response{
+				Status:        entry.status,
+				StatusCode:    entry.statusCode,
+				Proto:         entry.proto,
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        header,
+				Body:          ioutil.NopCloser(bytes.NewReader(entry.body)),
+				ContentLength: int64(len(entry.body)),
+				Request:       r,
+			}, nil
+		}
+	}
+	metrics.Add("cache_misses", 1)
+
+	resp, err := rc.next.RoundTrip(r)
+	if err != nil || noStore {
+		return resp, err
+	}
+	ttl := rc.freshness(r, resp)
+	if ttl == 0 || resp.ContentLength > rc.maxSize {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return resp, nil
+	}
+	now := time.Now()
+	rc.store(r, &cacheEntry{
+		status:     resp.Status,
+		statusCode: resp.StatusCode,
+		proto:      resp.Proto,
+		header:     resp.Header.Clone(),
+		body:       body,
+		stored:     now,
+		expires:    now.Add(ttl),
+	})
+	resp.Header.Set("X-Gohrec-Cache", "MISS")
+	return resp, nil
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// identifierKind is a kind of identifier found in the text of records, and
+// how its pseudonym is formatted from the keyed hash of its value.
+type identifierKind struct {
+	pattern *regexp.Regexp
+	format  func(value string, hash []byte) string
+}
+
+var identifierKinds = map[string]identifierKind{
+	"email": {regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), func(value string, hash []byte) string {
+		return "user-" + hex.EncodeToString(hash)[:10] + "@example.invalid"
+	}},
+	"uuid": {regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), func(value string, hash []byte) string {
+		h := hex.EncodeToString(hash)
+		return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+	}},
+}
+
+// pseudonymizer consistently replaces identifiers by pseudonyms derived from
+// a keyed hash, the same identifier getting the same pseudonym across a set
+// of records, preserving their references to each other.
+type pseudonymizer struct {
+	key    []byte
+	kinds  []string
+	fields map[string]bool
+}
+
+// makePseudonymizer returns the pseudonymizer of comma-separated identifier
+// kinds and fields, nil if there are none.
+func makePseudonymizer(kinds, fields string, key []byte) (*pseudonymizer, error) {
+	p := &pseudonymizer{key: key, fields: map[string]bool{}}
+	for _, kind := range strings.Split(kinds, ",") {
+		if kind = strings.TrimSpace(kind); kind == "" {
+			continue
+		}
+		if _, ok := identifierKinds[kind]; !ok && kind != "path-id" {
+			return nil, fmt.Errorf("Unknown identifier kind `%s`, expected `email`, `uuid` or `path-id`.", kind)
+		}
+		p.kinds = append(p.kinds, kind)
+	}
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			p.fields[strings.ToLower(field)] = true
+		}
+	}
+	if len(p.kinds) == 0 && len(p.fields) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func (p *pseudonymizer) hash(value string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// pseudonym returns the pseudonym of an identifier, keeping numeric ones
+// numeric and of the same length.
+func (p *pseudonymizer) pseudonym(value string) string {
+	hash := p.hash(value)
+	if value != "" && strings.Trim(value, "0123456789") == "" {
+		digits := make([]byte, len(value))
+		for i := range digits {
+			digits[i] = '0' + hash[i%len(hash)]%10
+		}
+		if digits[0] == '0' && value[0] != '0' {
+			digits[0] = '1' + hash[0]%9
+		}
+		return string(digits)
+	}
+	return "id-" + hex.EncodeToString(hash)[:16]
+}
+
+// text replaces the identifiers of the kinds found in a text.
+func (p *pseudonymizer) text(text string) string {
+	for _, name := range p.kinds {
+		kind, ok := identifierKinds[name]
+		if !ok {
+			continue
+		}
+		text = kind.pattern.ReplaceAllStringFunc(text, func(value string) string {
+			return kind.format(value, p.hash(strings.ToLower(value)))
+		})
+	}
+	return text
+}
+
+// path replaces the identifiers of the kinds found in the segments of a
+// path, and its other identifier segments with path-id.
+func (p *pseudonymizer) path(path string) string {
+	pathID := false
+	for _, kind := range p.kinds {
+		pathID = pathID || kind == "path-id"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if text := p.text(segment); text != segment {
+			segments[i] = text
+		} else if pathID && identifierSegment.MatchString(segment) {
+			segments[i] = p.pseudonym(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// uri pseudonymizes the path and the query values of a request URI.
+func (p *pseudonymizer) uri(uri string) string {
+	split := strings.SplitN(uri, "?", 2)
+	split[0] = p.path(split[0])
+	if len(split) == 2 {
+		params := strings.Split(split[1], "&")
+		for i, param := range params {
+			if kv := strings.SplitN(param, "=", 2); len(kv) == 2 && p.fields[strings.ToLower(kv[0])] {
+				params[i] = kv[0] + "=" + p.pseudonym(kv[1])
+			} else {
+				params[i] = p.text(param)
+			}
+		}
+		split[1] = strings.Join(params, "&")
+	}
+	return strings.Join(split, "?")
+}
+
+// values pseudonymizes `name: value` lines like headers and query parameters.
+func (p *pseudonymizer) values(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if split := strings.SplitN(line, ": ", 2); len(split) == 2 && p.fields[strings.ToLower(split[0])] {
+			out[i] = split[0] + ": " + p.pseudonym(split[1])
+		} else {
+			out[i] = p.text(line)
+		}
+	}
+	return out
+}
+
+// json pseudonymizes the values of the fields of a JSON document.
+func (p *pseudonymizer) json(node interface{}) interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if !p.fields[strings.ToLower(key)] {
+				value[key] = p.json(child)
+				continue
+			}
+			switch child := child.(type) {
+			case string:
+				value[key] = p.pseudonym(child)
+			case json.Number:
+				if pseudonym := p.pseudonym(child.String()); strings.Trim(pseudonym, "0123456789") == "" {
+					value[key] = json.Number(pseudonym)
+				} else {
+					value[key] = pseudonym
+				}
+			default:
+				value[key] = p.json(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = p.json(child)
+		}
+	case string:
+		return p.text(value)
+	}
+	return node
+}
+
+// body pseudonymizes a JSON body field by field, and a text one as a whole.
+func (p *pseudonymizer) body(body string) string {
+	if len(p.fields) > 0 {
+		if doc, ok := decodeJSON(body); ok {
+			return encodeJSON(p.json(doc))
+		}
+	}
+	return p.text(body)
+}
+
+// record pseudonymizes the identifiers of an exported record.
+func (p *pseudonymizer) record(record *exportRecord) {
+	if p == nil {
+		return
+	}
+	record.Path = p.path(record.Path)
+	record.URI = p.uri(record.URI)
+	record.Query = p.values(record.Query)
+	record.Headers = p.values(record.Headers)
+	if utf8.ValidString(record.Body) {
+		record.Body = p.body(record.Body)
+	}
+	record.SessionID = p.text(record.SessionID)
+	record.CorrelationID = p.text(record.CorrelationID)
+}
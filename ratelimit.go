@@ -0,0 +1,128 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket limiter keyed by client.
+type rateLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	by      string
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	cleaned time.Time
+}
+
+// makeRateLimiter parses a rate like `100/s`, `600/m` or `1000/h`, keyed by
+// `remote-ip` or `header:<name>`.
+func makeRateLimiter(rate string, by string) (*rateLimiter, error) {
+	if rate == "" {
+		return nil, nil
+	}
+	split := strings.SplitN(rate, "/", 2)
+	count, err := strconv.ParseFloat(split[0], 64)
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("Invalid rate limit `%s`, expected `<count>/<s|m|h>`.", rate)
+	}
+	period := time.Second
+	if len(split) == 2 {
+		switch split[1] {
+		case "s":
+		case "m":
+			period = time.Minute
+		case "h":
+			period = time.Hour
+		default:
+			if period, err = time.ParseDuration(split[1]); err != nil {
+				return nil, fmt.Errorf("Invalid rate limit `%s`, expected `<count>/<s|m|h>`.", rate)
+			}
+		}
+	}
+	if by != "remote-ip" && !strings.HasPrefix(by, "header:") {
+		return nil, fmt.Errorf("Invalid rate limit key `%s`, expected `remote-ip` or `header:<name>`.", by)
+	}
+	return &rateLimiter{
+		rate:    count / period.Seconds(),
+		burst:   count,
+		by:      by,
+		buckets: map[string]*tokenBucket{},
+		cleaned: time.Now(),
+	}, nil
+}
+
+func (rl *rateLimiter) key(r *http.Request) string {
+	if strings.HasPrefix(rl.by, "header:") {
+		return r.Header.Get(strings.TrimPrefix(rl.by, "header:"))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow consumes a token for the key, returning the delay before the next
+// token is available when none is left.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.cleaned) > time.Minute {
+		for k, bucket := range rl.buckets {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate >= rl.burst {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.cleaned = now
+	}
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func (rl *rateLimiter) wrap(ghr goHRec, next http.HandlerFunc) http.HandlerFunc {
+	if rl == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, delay := rl.allow(rl.key(r)); !ok {
+			metrics.Add("rate_limited", 1)
+			ghr.log(slog.LevelWarn, "Skipped: rate limited.", "request", makeRequestName(r))
+			ghr.recordSkip(r, "rate-limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, "Skipped: rate limited.")
+			return
+		}
+		next(w, r)
+	}
+}
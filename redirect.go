@@ -0,0 +1,35 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// redirectPolicy returns the CheckRedirect function of a client following up
+// to max redirects, or returning the redirect responses if follow is false.
+func redirectPolicy(follow bool, max int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if !follow {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirect(s)", max)
+		}
+		return nil
+	}
+}
+
+// redirectChain returns the redirects followed to get a response, like
+// `GET http://a/x -> 302 Found -> http://b/y`.
+func redirectChain(resp *http.Response) []string {
+	chain := []string{}
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		from := req.Response.Request
+		chain = append([]string{fmt.Sprintf("%s %s -> %s -> %s", from.Method, from.URL, req.Response.Status, req.URL)}, chain...)
+	}
+	return chain
+}
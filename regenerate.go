@@ -0,0 +1,83 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// headerRegenerator replaces idempotency/nonce header values with fresh ones,
+// consistently for a given original value, and keeps track of the mapping.
+type headerRegenerator struct {
+	names   map[string]bool
+	mutex   sync.Mutex
+	values  map[string]string
+	mapping *log.Logger
+}
+
+func makeHeaderRegenerator(names string, mapFile string) (*headerRegenerator, error) {
+	if names == "" {
+		return nil, nil
+	}
+	hr := &headerRegenerator{names: map[string]bool{}, values: map[string]string{}}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			hr.names[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	if mapFile != "" {
+		f, err := os.OpenFile(mapFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Error while opening %s: %s", mapFile, err)
+		}
+		hr.mapping = log.New(f, "", 0)
+	}
+	return hr, nil
+}
+
+func makeUUID() string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+func (hr *headerRegenerator) regenerate(name string, value string) string {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	key := name + "\x00" + value
+	if regenerated, ok := hr.values[key]; ok {
+		return regenerated
+	}
+	regenerated := makeUUID()
+	hr.values[key] = regenerated
+	if hr.mapping != nil {
+		hr.mapping.Printf("%s\t%s\t%s", name, value, regenerated)
+	}
+	return regenerated
+}
+
+func (hr *headerRegenerator) apply(record *redoRecord) {
+	if hr == nil {
+		return
+	}
+	headers := make([]string, len(record.Headers))
+	for i, header := range record.Headers {
+		headers[i] = header
+		split := strings.SplitN(header, ": ", 2)
+		if len(split) == 2 && hr.names[http.CanonicalHeaderKey(split[0])] {
+			headers[i] = split[0] + ": " + hr.regenerate(http.CanonicalHeaderKey(split[0]), split[1])
+		}
+	}
+	record.Headers = headers
+}
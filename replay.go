@@ -0,0 +1,107 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type redoFile struct {
+	name   string
+	record redoRecord
+}
+
+func loadRedoDir(dir string) ([]redoFile, error) {
+	files := []redoFile{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !(isRecordFile(path, "request") || isRecordFile(path, "pair")) {
+			return nil
+		}
+		record, err := loadRedoRecord(path)
+		if err != nil {
+			return fmt.Errorf("%s (%s)", err, path)
+		}
+		files = append(files, redoFile{name: path, record: record})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].record.DateUnixNano < files[j].record.DateUnixNano
+	})
+	return files, nil
+}
+
+func (rd redoer) redoFiles(files []redoFile) {
+	for _, file := range files {
+		log.Printf("Redoing: %s", file.name)
+		if rd.comparison != nil {
+			rd.compare(file)
+			continue
+		}
+		if rd.verification != nil {
+			rd.verify(file)
+			continue
+		}
+		if err := rd.send(file.record); err != nil {
+			log.Printf("%s (%s)", err, file.name)
+		}
+	}
+}
+
+func (rd redoer) redoDir(dir string, partitionByHeader string, ls loadSettings) error {
+	files, err := loadRedoDir(dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d request(s) to redo in %s", len(files), dir)
+	if rd.amplifier != nil {
+		files = rd.amplifier.apply(files)
+		log.Printf("Amplified to %d request(s)", len(files))
+	}
+
+	if ls.enabled() {
+		rd.redoLoad(files, ls)
+		return nil
+	}
+
+	if partitionByHeader == "" {
+		rd.redoFiles(files)
+		return nil
+	}
+
+	keys := []string{}
+	partitions := map[string][]redoFile{}
+	for _, file := range files {
+		key := file.record.header(partitionByHeader)
+		if _, ok := partitions[key]; !ok {
+			keys = append(keys, key)
+		}
+		partitions[key] = append(partitions[key], file)
+	}
+	log.Printf("Partitioned requests in %d group(s) by %s", len(keys), partitionByHeader)
+
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(files []redoFile) {
+			defer wg.Done()
+			rd.redoFiles(files)
+		}(partitions[key])
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+type reportCount struct {
+	Name  string
+	Count int
+}
+
+func sortedCounts(counts map[string]int, max int) []reportCount {
+	out := []reportCount{}
+	for name, count := range counts {
+		out = append(out, reportCount{name, count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if max > 0 && len(out) > max {
+		out = out[:max]
+	}
+	return out
+}
+
+type htmlReport struct {
+	Date                  time.Time
+	Dir                   string
+	Exchanges, Unanswered int
+	First, Last           time.Time
+	Statuses, Methods     []reportCount
+	Hosts, Paths          []reportCount
+	Errors, Slowest       []exchange
+	Comparison            *comparisonReport
+}
+
+// joinExchanges groups request and response records by ID, in request order.
+func joinExchanges(records []exportRecord) []exchange {
+	byID := map[string]*exchange{}
+	order := []string{}
+	for i := range records {
+		record := &records[i]
+		ex, ok := byID[record.ID]
+		if !ok {
+			ex = &exchange{ID: record.ID}
+			byID[record.ID] = ex
+			order = append(order, record.ID)
+		}
+		if record.kind == "request" {
+			ex.Request = record
+		} else {
+			ex.Response = record
+		}
+	}
+	exchanges := []exchange{}
+	for _, id := range order {
+		ex := byID[id]
+		if ex.Request == nil {
+			continue
+		}
+		if ex.Response != nil {
+			ex.Duration = time.Duration(ex.Response.DateUnixNano - ex.Request.DateUnixNano)
+		}
+		exchanges = append(exchanges, *ex)
+	}
+	return exchanges
+}
+
+func makeHTMLReport(dir string, exchanges []exchange, samples int) htmlReport {
+	report := htmlReport{Date: time.Now(), Dir: dir, Exchanges: len(exchanges)}
+	statuses, methods, hosts, paths := map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+	answered := []exchange{}
+	for _, ex := range exchanges {
+		date := ex.Request.DateUTC
+		if report.First.IsZero() || date.Before(report.First) {
+			report.First = date
+		}
+		if date.After(report.Last) {
+			report.Last = date
+		}
+		methods[ex.Request.Method]++
+		hosts[ex.Request.Host]++
+		paths[ex.Request.Method+" "+ex.Request.Path]++
+		if ex.Response == nil {
+			report.Unanswered++
+			statuses["none"]++
+			continue
+		}
+		statuses[fmt.Sprintf("%dxx", ex.Response.StatusCode/100)]++
+		answered = append(answered, ex)
+		if ex.Response.StatusCode >= 400 && len(report.Errors) < samples {
+			report.Errors = append(report.Errors, ex)
+		}
+	}
+	report.Statuses = sortedCounts(statuses, 0)
+	report.Methods = sortedCounts(methods, 0)
+	report.Hosts = sortedCounts(hosts, 10)
+	report.Paths = sortedCounts(paths, 20)
+
+	sort.SliceStable(answered, func(i, j int) bool { return answered[i].Duration > answered[j].Duration })
+	if len(answered) > samples {
+		answered = answered[:samples]
+	}
+	report.Slowest = answered
+	return report
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gohrec report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: .2em .5em; text-align: left; vertical-align: top; }
+pre { background: #f4f4f4; padding: .5em; margin: 0; max-width: 60em; overflow-x: auto; white-space: pre-wrap; }
+.divergent { color: #b00; }
+</style>
+</head>
+<body>
+<h1>gohrec report</h1>
+<p>Records of <code>{{.Dir}}</code>, generated on {{.Date.Format "2006-01-02 15:04:05 MST"}}.</p>
+
+<h2>Traffic overview</h2>
+<p>{{.Exchanges}} exchange(s){{if .Exchanges}} from {{.First.Format "2006-01-02 15:04:05"}} to {{.Last.Format "2006-01-02 15:04:05"}} UTC{{end}}, {{.Unanswered}} without response.</p>
+<table><tr><th>Status</th><th>Count</th></tr>{{range .Statuses}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+<table><tr><th>Method</th><th>Count</th></tr>{{range .Methods}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+<table><tr><th>Host</th><th>Count</th></tr>{{range .Hosts}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+<table><tr><th>Endpoint</th><th>Count</th></tr>{{range .Paths}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+
+<h2>Error samples</h2>
+{{if .Errors}}<table><tr><th>Date (UTC)</th><th>Request</th><th>Status</th><th>Response body</th></tr>
+{{range .Errors}}<tr><td>{{.Request.DateUTC.Format "2006-01-02 15:04:05.000"}}</td><td>{{.Request.Method}} {{.Request.Host}}{{.Request.URI}}<br><code>{{.ID}}</code></td><td>{{.Response.Status}}</td><td><pre>{{truncate 1024 .Response.Body}}</pre></td></tr>
+{{end}}</table>{{else}}<p>No error.</p>{{end}}
+
+<h2>Slowest exchanges</h2>
+{{if .Slowest}}<table><tr><th>Duration</th><th>Request</th><th>Status</th></tr>
+{{range .Slowest}}<tr><td>{{.Duration}}</td><td>{{.Request.Method}} {{.Request.Host}}{{.Request.URI}}<br><code>{{.ID}}</code></td><td>{{.Response.Status}}</td></tr>
+{{end}}</table>{{else}}<p>No exchange.</p>{{end}}
+
+{{with .Comparison}}<h2>Replay comparison</h2>
+<p>{{.Requests}} request(s) replayed against {{join .Targets ", "}}: {{.Divergents}} divergent.</p>
+<table><tr><th>Request</th><th>Divergences</th><th>Responses</th></tr>
+{{range .Entries}}{{if .Divergent}}<tr class="divergent"><td>{{.Method}} {{.URI}}</td><td>{{join .Divergences ", "}}</td><td>{{range .Responses}}{{.Target}}: {{if .Error}}{{.Error}}{{else}}{{.Status}}, {{.BodySize}} bytes, {{.Duration}}{{end}}<br>{{end}}</td></tr>
+{{end}}{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+func report() {
+	reporter := flag.NewFlagSet("report", flag.PanicOnError)
+	in := reporter.String("in", ".", "Directory of the records to report.")
+	out := reporter.String("out", "report.html", "HTML file to write.")
+	compareReport := reporter.String("compare-report", "", "If set, JSON comparison report of `redo --compare-report` whose divergences are included.")
+	samples := reporter.Int("samples", 20, "Maximum number of error samples and slowest exchanges listed.")
+	reporter.Parse(os.Args[2:])
+
+	log.Printf("  in: %s", *in)
+	log.Printf("  out: %s", *out)
+	log.Printf("  compare-report: %s", *compareReport)
+	log.Printf("  samples: %d", *samples)
+
+	records, err := loadExportRecords(*in, "request", "response")
+	if err != nil {
+		log.Fatalf("Error while loading records: %s", err)
+	}
+	report := makeHTMLReport(*in, joinExchanges(records), *samples)
+
+	if *compareReport != "" {
+		content, err := ioutil.ReadFile(*compareReport)
+		if err != nil {
+			log.Fatalf("Error while reading comparison report: %s", err)
+		}
+		report.Comparison = &comparisonReport{}
+		if err := json.Unmarshal(content, report.Comparison); err != nil {
+			log.Fatalf("Error while unmarshalling comparison report: %s", err)
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Error while creating report: %s", err)
+	}
+	defer f.Close()
+	if err := reportTemplate.Execute(f, report); err != nil {
+		log.Fatalf("Error while writing report: %s", err)
+	}
+	log.Printf("Reported %d exchange(s) into %s.", report.Exchanges, *out)
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// retryThread is the first attempt of a logical request and its last retry.
+type retryThread struct {
+	id       string
+	attempts int
+	last     time.Time
+}
+
+// retryTracker links client retries of a logical request, identified by its
+// idempotency key or else the hash of its method, host, URI and body, to its
+// first attempt when they happen within the window since the last attempt.
+type retryTracker struct {
+	window  time.Duration
+	mutex   sync.Mutex
+	threads map[string]*retryThread
+	pruned  time.Time
+}
+
+func makeRetryTracker(window time.Duration) *retryTracker {
+	if window <= 0 {
+		return nil
+	}
+	return &retryTracker{window: window, threads: map[string]*retryThread{}}
+}
+
+// key identifies the logical request of a record, before its redaction.
+func (rt *retryTracker) key(record *requestRecord, body []byte) string {
+	if rt == nil {
+		return ""
+	}
+	for _, name := range []string{"Idempotency-Key", "X-Idempotency-Key"} {
+		if key := findHeader(record.Headers, name); key != "" {
+			return name + ": " + key
+		}
+	}
+	hash := sha256.New()
+	for _, field := range []string{record.Method, record.Host, record.URI} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// thread sets RetryOf and Attempt of a record retrying an earlier one.
+func (rt *retryTracker) thread(record *requestRecord, key string, received time.Time) {
+	if rt == nil || key == "" {
+		return
+	}
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	if received.Sub(rt.pruned) > rt.window {
+		for k, thread := range rt.threads {
+			if received.Sub(thread.last) > rt.window {
+				delete(rt.threads, k)
+			}
+		}
+		rt.pruned = received
+	}
+
+	thread, ok := rt.threads[key]
+	if !ok || received.Sub(thread.last) > rt.window {
+		rt.threads[key] = &retryThread{id: record.ID, attempts: 1, last: received}
+		return
+	}
+	thread.attempts++
+	thread.last = received
+	record.RetryOf = thread.id
+	record.Attempt = thread.attempts
+	metrics.Add("retries_detected", 1)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// routeFlag forwards requests whose path, or host when prefixed by `host:`,
+// matches the pattern to the target URL.
+type routeFlag struct {
+	host    bool
+	pattern *regexp.Regexp
+	target  *url.URL
+}
+
+func (rf *routeFlag) Match(r *http.Request) bool {
+	if rf.host {
+		return rf.pattern.MatchString(r.Host)
+	}
+	return rf.pattern.MatchString(r.URL.Path)
+}
+
+func (rf *routeFlag) Set(value string) error {
+	split := strings.SplitN(value, "=>", 2)
+	if len(split) != 2 {
+		return fmt.Errorf("Invalid route `%s`, expected `[host:]regex=>url`.", value)
+	}
+	pattern := strings.TrimSpace(split[0])
+	if strings.HasPrefix(pattern, "host:") {
+		rf.host = true
+		pattern = strings.TrimPrefix(pattern, "host:")
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	target, err := url.Parse(strings.TrimSpace(split[1]))
+	if err != nil {
+		return err
+	}
+	if !target.IsAbs() {
+		return fmt.Errorf("Invalid route `%s`, target must be an absolute URL.", value)
+	}
+	rf.pattern = regex
+	rf.target = target
+	return nil
+}
+
+func (rf *routeFlag) String() string {
+	if rf.pattern == nil {
+		return "[host:]regex=>url"
+	}
+	prefix := ""
+	if rf.host {
+		prefix = "host:"
+	}
+	return prefix + rf.pattern.String() + "=>" + rf.target.String()
+}
+
+type arrayRouteFlag []routeFlag
+
+func (arf *arrayRouteFlag) Match(r *http.Request) *url.URL {
+	for _, item := range *arf {
+		if item.Match(r) {
+			return item.target
+		}
+	}
+	return nil
+}
+
+func (arf *arrayRouteFlag) Set(value string) error {
+	item := routeFlag{}
+	if err := item.Set(value); err != nil {
+		return err
+	}
+	*arf = append(*arf, item)
+	return nil
+}
+
+func (arf *arrayRouteFlag) String() string {
+	if arf == nil {
+		return "[]"
+	}
+	out := []string{}
+	for _, item := range *arf {
+		out = append(out, "`"+item.String()+"`")
+	}
+	return "[ " + strings.Join(out, ", ") + " ]"
+}
+
+// Load adds the routes of a file, one per line, ignoring empty lines and
+// those starting with `#`.
+func (arf *arrayRouteFlag) Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := arf.Set(text); err != nil {
+			return fmt.Errorf("%s:%d: %s", file, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// rewriteFlag is a sed-like substitution `s#regex#replacement#` applied to the
+// path of proxied requests, any character following `s` being the delimiter.
+type rewriteFlag struct {
+	regex   *regexp.Regexp
+	replace string
+	raw     string
+}
+
+var sedGroup = regexp.MustCompile(`\\(\d)`)
+
+func (rf *rewriteFlag) Rewrite(path string) string {
+	return rf.regex.ReplaceAllString(path, rf.replace)
+}
+
+func (rf *rewriteFlag) Set(value string) error {
+	if len(value) < 4 || value[0] != 's' {
+		return fmt.Errorf("Invalid rewrite `%s`, expected `s#regex#replacement#`.", value)
+	}
+	delimiter := value[1:2]
+	split := strings.Split(value[2:], delimiter)
+	if len(split) != 3 || split[2] != "" {
+		return fmt.Errorf("Invalid rewrite `%s`, expected `s%sregex%sreplacement%s`.", value, delimiter, delimiter, delimiter)
+	}
+	regex, err := regexp.Compile(split[0])
+	if err != nil {
+		return err
+	}
+	rf.regex = regex
+	rf.replace = sedGroup.ReplaceAllString(split[1], "$${$1}")
+	rf.raw = value
+	return nil
+}
+
+func (rf *rewriteFlag) String() string {
+	if rf.raw == "" {
+		return "s#regex#replacement#"
+	}
+	return rf.raw
+}
+
+type arrayRewriteFlag []rewriteFlag
+
+func (arf *arrayRewriteFlag) Rewrite(path string) string {
+	for _, item := range *arf {
+		path = item.Rewrite(path)
+	}
+	return path
+}
+
+func (arf *arrayRewriteFlag) Set(value string) error {
+	item := rewriteFlag{}
+	if err := item.Set(value); err != nil {
+		return err
+	}
+	*arf = append(*arf, item)
+	return nil
+}
+
+func (arf *arrayRewriteFlag) String() string {
+	if arf == nil {
+		return "[]"
+	}
+	out := []string{}
+	for _, item := range *arf {
+		out = append(out, "`"+item.String()+"`")
+	}
+	return "[ " + strings.Join(out, ", ") + " ]"
+}
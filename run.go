@@ -0,0 +1,155 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// systemCABundles are the usual locations of the system CA bundle, extended
+// with the MITM CA for the wrapped command.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// writeCABundle writes the system CA bundle followed by the CA certificate.
+func writeCABundle(bundleFile, caFile string) error {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	bundles := systemCABundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		bundles = append([]string{file}, bundles...)
+	}
+	bundle := []byte{}
+	for _, file := range bundles {
+		if content, err := ioutil.ReadFile(file); err == nil {
+			bundle = append(content, '\n')
+			break
+		}
+	}
+	return ioutil.WriteFile(bundleFile, append(bundle, ca...), 0644)
+}
+
+// freeLocalAddress returns a local address with a free port.
+func freeLocalAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// waitListening waits for an address to accept connections.
+func waitListening(addr string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+// run records the outbound traffic of a command, started with a recorder in
+// forward proxy mode as its HTTP and HTTPS proxy, HTTPS requests being
+// intercepted with a temporary CA trusted by the command.
+func run() {
+	args := os.Args[2:]
+	recordArgs, command := args, []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			recordArgs, command = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(command) == 0 {
+		log.Fatal("Expected a command after `--`, like `gohrec run -- curl https://example.com`.")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Error while locating gohrec: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "gohrec-run-")
+	if err != nil {
+		log.Fatalf("Error while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	caCert, caKey, caBundle := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"), filepath.Join(dir, "ca-bundle.pem")
+	if err := writeMITMCA(caCert, caKey, 24*time.Hour); err != nil {
+		log.Fatalf("Error while generating CA: %s", err)
+	}
+	if err := writeCABundle(caBundle, caCert); err != nil {
+		log.Fatalf("Error while writing CA bundle: %s", err)
+	}
+	listen, err := freeLocalAddress()
+	if err != nil {
+		log.Fatalf("Error while finding a free port: %s", err)
+	}
+
+	log.Printf("  listen: %s", listen)
+	log.Printf("  command: %q", command)
+
+	recorder := exec.Command(self, append([]string{"record", "--proxy-dynamic", "--listen", listen, "--mitm-ca-cert", caCert, "--mitm-ca-key", caKey}, recordArgs...)...)
+	recorder.Stdout, recorder.Stderr = os.Stdout, os.Stderr
+	// The recorder gets its own process group, so that the signals of the
+	// terminal only reach the command and the recorder records until it exits.
+	recorder.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := recorder.Start(); err != nil {
+		log.Fatalf("Error while starting recorder: %s", err)
+	}
+	stopRecorder := func() {
+		recorder.Process.Signal(syscall.SIGTERM)
+		recorder.Wait()
+	}
+	if !waitListening(listen, 10*time.Second) {
+		stopRecorder()
+		log.Fatalf("Recorder not listening on %s.", listen)
+	}
+
+	// Signals are left to the command, the recorder being stopped once it has
+	// exited.
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGINT, syscall.SIGTERM)
+
+	proxy := "http://" + listen
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"HTTP_PROXY="+proxy, "HTTPS_PROXY="+proxy, "http_proxy="+proxy, "https_proxy="+proxy,
+		"SSL_CERT_FILE="+caBundle, "CURL_CA_BUNDLE="+caBundle, "REQUESTS_CA_BUNDLE="+caBundle,
+		"NODE_EXTRA_CA_CERTS="+caCert, "GOHREC_CA_CERT="+caCert,
+	)
+	code := 0
+	if err := cmd.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			code = exit.ExitCode()
+		} else {
+			log.Printf("Error while running command: %s", err)
+			code = 127
+		}
+	}
+
+	stopRecorder()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// responseSaver writes the responses of redone requests as response records,
+// named and indexed like the ones of `gohrec record`, so that replays can be
+// compared with the other subcommands.
+type responseSaver struct {
+	dir   string
+	mutex sync.Mutex
+	index *os.File
+}
+
+func makeResponseSaver(dir string) (*responseSaver, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Error while creating --save-response directory: %s", err)
+	}
+	index, err := os.OpenFile(filepath.Join(dir, "index.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error while opening index.log: %s", err)
+	}
+	return &responseSaver{dir: dir, index: index}, nil
+}
+
+// save writes the response to a request with its body, the record having the
+// ID of the redone request if it had one, and returns its file name.
+func (rs *responseSaver) save(id string, req *http.Request, resp *http.Response, body []byte) (string, error) {
+	received := time.Now()
+	name := fmt.Sprintf("[redo] %s %s", req.Method, req.URL)
+	if id == "" {
+		id = makeRequestID(name, received)
+	}
+	record := responseRecord{
+		baseInfo{
+			ID:                id,
+			Date:              received,
+			DateUTC:           received.UTC(),
+			DateUnixNano:      received.UnixNano(),
+			Protocol:          resp.Proto,
+			Headers:           dumpValues(resp.Header),
+			ContentLength:     resp.ContentLength,
+			Trailers:          dumpValues(resp.Trailer),
+			TransferEncodings: resp.TransferEncoding,
+		},
+		responseInfo{
+			Compressed: !resp.Uncompressed,
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+		},
+	}
+	record.setBody(body)
+
+	content, err := json.MarshalIndent(record, "", " ")
+	if err != nil {
+		return "", err
+	}
+	file := fmt.Sprintf("%s%09d.%s.response.json", received.Format(defaultDateFormat), received.Nanosecond(), id)
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(rs.dir, file)), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(rs.dir, file), content, 0644); err != nil {
+		return "", err
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	_, err = fmt.Fprintf(rs.index, "%s\t%s\t%s\n", id, file, name)
+	return filepath.Join(rs.dir, file), err
+}
+
+func (rs *responseSaver) close() {
+	if rs != nil {
+		rs.index.Close()
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type piiDetector struct {
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}
+
+var piiDetectors = map[string]piiDetector{
+	"email": {regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), nil},
+	"cc":    {regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), isLuhnValid},
+	"iban":  {regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`), isIBANValid},
+	"ssn":   {regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), isSSNValid},
+}
+
+func digitsOnly(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+func isLuhnValid(value string) bool {
+	digits := digitsOnly(value)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+func isIBANValid(value string) bool {
+	iban := strings.Replace(value, " ", "", -1)
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	rearranged := iban[4:] + iban[:4]
+	var numeric strings.Builder
+	for _, r := range rearranged {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&numeric, "%d", r-'A'+10)
+		} else {
+			numeric.WriteRune(r)
+		}
+	}
+	number, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(number, big.NewInt(97)).Int64() == 1
+}
+
+func isSSNValid(value string) bool {
+	return !strings.HasPrefix(value, "000") && !strings.HasPrefix(value, "666") && value[0] != '9' &&
+		value[4:6] != "00" && value[7:] != "0000"
+}
+
+// maskSample keeps only the first and last two characters of a sample.
+func maskSample(value string) string {
+	if len(value) <= 4 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:2] + strings.Repeat("*", len(value)-4) + value[len(value)-2:]
+}
+
+type piiFinding struct {
+	File, Field, Type, Sample string
+	Offset                    int
+}
+
+func scanText(file string, field string, text string, detectors []string) []piiFinding {
+	findings := []piiFinding{}
+	for _, name := range detectors {
+		detector := piiDetectors[name]
+		for _, match := range detector.pattern.FindAllStringIndex(text, -1) {
+			value := text[match[0]:match[1]]
+			if detector.valid != nil && !detector.valid(value) {
+				continue
+			}
+			findings = append(findings, piiFinding{File: file, Field: field, Type: name, Offset: match[0], Sample: maskSample(value)})
+		}
+	}
+	return findings
+}
+
+func scanRecord(file string, detectors []string) ([]piiFinding, error) {
+	content, err := readRecordFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, err
+	}
+
+	return scanValue(file, "", record, detectors), nil
+}
+
+// scanValue scans the strings of a JSON value, nested objects like the
+// request and response of pair records included.
+func scanValue(file, field string, value interface{}, detectors []string) []piiFinding {
+	findings := []piiFinding{}
+	switch value := value.(type) {
+	case string:
+		findings = append(findings, scanText(file, field, value, detectors)...)
+	case []interface{}:
+		for i, item := range value {
+			findings = append(findings, scanValue(file, fmt.Sprintf("%s[%d]", field, i), item, detectors)...)
+		}
+	case map[string]interface{}:
+		fields := []string{}
+		for name := range value {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+		for _, name := range fields {
+			path := name
+			if field != "" {
+				path = field + "." + name
+			}
+			findings = append(findings, scanValue(file, path, value[name], detectors)...)
+		}
+	}
+	return findings
+}
+
+func scan() {
+	scanner := flag.NewFlagSet("scan", flag.PanicOnError)
+	detect := scanner.String("detect", "email,cc,iban,ssn", "Comma-separated list of PII kinds to detect: `email`, `cc`, `iban`, `ssn`.")
+	format := scanner.String("format", "text", "Output format: `text` or `json` (one finding per line).")
+	scanner.Parse(os.Args[2:])
+
+	log.Printf("  detect: %s", *detect)
+	log.Printf("  format: %s", *format)
+
+	detectors := []string{}
+	for _, name := range strings.Split(*detect, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := piiDetectors[name]; !ok {
+			log.Fatalf("Unknown PII kind `%s`, expected `email`, `cc`, `iban` or `ssn`.", name)
+		}
+		detectors = append(detectors, name)
+	}
+
+	dirs := scanner.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	files, withPII, total := 0, 0, 0
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !(isRecordFile(path, "request") || isRecordFile(path, "response") || isRecordFile(path, "pair")) {
+				return nil
+			}
+			files++
+			findings, err := scanRecord(path, detectors)
+			if err != nil {
+				log.Printf("Error while scanning %s: %s", path, err)
+				return nil
+			}
+			if len(findings) > 0 {
+				withPII++
+				total += len(findings)
+			}
+			for _, finding := range findings {
+				if *format == "json" {
+					encoder.Encode(finding)
+				} else {
+					fmt.Printf("%s\t%s\t%d\t%s\t%s\n", finding.File, finding.Field, finding.Offset, finding.Type, finding.Sample)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error while scanning %s: %s", dir, err)
+		}
+	}
+	log.Printf("Scanned %d record(s): %d with likely PII, %d finding(s)", files, withPII, total)
+}
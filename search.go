@@ -0,0 +1,424 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var errSearchDone = errors.New("search done")
+
+// jsonStringReader reads the runes of a JSON string value, unescaping them,
+// up to its closing quote.
+type jsonStringReader struct {
+	reader *bufio.Reader
+	done   bool
+	err    error
+}
+
+func (sr *jsonStringReader) readHex() (rune, error) {
+	var hex [4]byte
+	if _, err := io.ReadFull(sr.reader, hex[:]); err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(string(hex[:]), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid \\u escape: %s", hex[:])
+	}
+	return rune(value), nil
+}
+
+func (sr *jsonStringReader) ReadRune() (rune, int, error) {
+	if sr.done {
+		return 0, 0, io.EOF
+	}
+	r, size, err := sr.reader.ReadRune()
+	if err != nil {
+		sr.done, sr.err = true, io.ErrUnexpectedEOF
+		return 0, 0, sr.err
+	}
+	switch r {
+	case '"':
+		sr.done = true
+		return 0, 0, io.EOF
+	case '\\':
+		escape, _, err := sr.reader.ReadRune()
+		if err != nil {
+			sr.done, sr.err = true, io.ErrUnexpectedEOF
+			return 0, 0, sr.err
+		}
+		switch escape {
+		case 'b':
+			r = '\b'
+		case 'f':
+			r = '\f'
+		case 'n':
+			r = '\n'
+		case 'r':
+			r = '\r'
+		case 't':
+			r = '\t'
+		case 'u':
+			if r, err = sr.readHex(); err != nil {
+				sr.done, sr.err = true, err
+				return 0, 0, err
+			}
+			if utf16.IsSurrogate(r) {
+				if next, err := sr.reader.Peek(2); err == nil && string(next) == `\u` {
+					sr.reader.Discard(2)
+					low, err := sr.readHex()
+					if err != nil {
+						sr.done, sr.err = true, err
+						return 0, 0, err
+					}
+					r = utf16.DecodeRune(r, low)
+				}
+			}
+			if utf16.IsSurrogate(r) {
+				r = utf8.RuneError
+			}
+		default:
+			r = escape
+		}
+		return r, utf8.RuneLen(r), nil
+	}
+	return r, size, nil
+}
+
+// drain reads the rest of the string, returning the error of a truncated one.
+func (sr *jsonStringReader) drain() error {
+	for !sr.done {
+		sr.ReadRune()
+	}
+	return sr.err
+}
+
+// searchRecordBodies scans a record file as a stream, matching the bodies of
+// the kind (`request`, `response` or `any`) against a pattern without
+// loading them whole, pair records included.
+func searchRecordBodies(file, kind string, pattern *regexp.Regexp) (bool, error) {
+	f, err := openRecordFile(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	reader := bufio.NewReaderSize(f, 64*1024)
+
+	fileKind := ""
+	for _, k := range []string{"request", "response"} {
+		if isRecordFile(file, k) {
+			fileKind = k
+		}
+	}
+
+	containers, keys := []byte{}, []string{}
+	expectKey := false
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		switch c {
+		case '{', '[':
+			containers, keys = append(containers, c), append(keys, "")
+			expectKey = c == '{'
+		case '}', ']':
+			if len(containers) == 0 {
+				return false, fmt.Errorf("unexpected %q", c)
+			}
+			containers, keys = containers[:len(containers)-1], keys[:len(keys)-1]
+		case ',':
+			expectKey = len(containers) > 0 && containers[len(containers)-1] == '{'
+		case ':':
+			expectKey = false
+		case '"':
+			value := &jsonStringReader{reader: reader}
+			if expectKey {
+				var key strings.Builder
+				for r, _, err := value.ReadRune(); err == nil; r, _, err = value.ReadRune() {
+					key.WriteRune(r)
+				}
+				if value.err != nil {
+					return false, value.err
+				}
+				keys[len(keys)-1] = key.String()
+				continue
+			}
+
+			bodyKind := ""
+			if len(keys) == 1 && keys[0] == "Body" {
+				bodyKind = fileKind
+			} else if len(keys) == 2 && keys[1] == "Body" && (keys[0] == "Request" || keys[0] == "Response") {
+				bodyKind = strings.ToLower(keys[0])
+			}
+			if bodyKind != "" && (kind == "any" || kind == bodyKind) && pattern.MatchReader(value) {
+				return true, nil
+			}
+			if err := value.drain(); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// recordIDOfFile returns the ID of a record file named by saveJSON.
+func recordIDOfFile(file string) string {
+	name := filepath.Base(file)
+	for _, ext := range compressExtensions {
+		if ext != "" {
+			name = strings.TrimSuffix(name, ext)
+		}
+	}
+	name = strings.TrimSuffix(name, ".json")
+	if i := strings.LastIndex(name, "."); i > -1 {
+		name = name[:i]
+	}
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// searchFiles matches the record files sent by files with a pool of workers,
+// calling found for each match, in no particular order, until it returns
+// errSearchDone.
+func searchFiles(files <-chan string, workers int, match func(string) (bool, error), found func(string) error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	done := false
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				mutex.Lock()
+				stop := done
+				mutex.Unlock()
+				if stop {
+					continue
+				}
+				ok, err := match(file)
+				if err != nil {
+					log.Printf("Error while searching %s: %s", file, err)
+					continue
+				}
+				if !ok {
+					continue
+				}
+				mutex.Lock()
+				if !done && found(file) == errSearchDone {
+					done = true
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// searchCandidates returns the record files of dir whose request matches the
+// method and path patterns and which were recorded since a date, reading
+// the index if any, or else the records.
+func searchCandidates(dir string, method, path *regexp.Regexp, since time.Time) ([]string, error) {
+	entries := []indexEntry{}
+	if lines, err := readLines(filepath.Join(dir, "index.log")); err == nil {
+		for _, line := range lines {
+			if fields := strings.SplitN(line, "\t", 3); len(fields) == 3 {
+				entries = append(entries, indexEntry{id: fields[0], file: fields[1], req: fields[2]})
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else if entries, err = scanIndexEntries(dir); err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.file)
+		if fields := strings.SplitN(entry.req, " ", 3); len(fields) == 3 {
+			if method != nil && !method.MatchString(fields[1]) {
+				continue
+			}
+			if path != nil && !path.MatchString(pathOfRequestName(entry.req)) {
+				continue
+			}
+		} else if method != nil || path != nil {
+			continue
+		}
+		if !since.IsZero() {
+			date := time.Unix(0, entry.date)
+			if entry.date == 0 {
+				info, err := os.Stat(file)
+				if err != nil {
+					continue
+				}
+				date = info.ModTime()
+			}
+			if date.Before(since) {
+				continue
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// parseSince parses a date (RFC 3339 or `2006-01-02`) or a duration before
+// now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if date, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid --since `%s`, expected a date (like `2020-06-01`) or a duration (like `24h`).", value)
+}
+
+func search() {
+	searcher := flag.NewFlagSet("search", flag.PanicOnError)
+	method := searcher.String("method", "", "If set, methods (like `POST` or `PUT,PATCH`) or pattern of the requests of the searched records.")
+	path := searcher.String("path", "", "If set, URL path pattern of the requests of the searched records.")
+	since := searcher.String("since", "", "If set, date (like `2020-06-01`) or duration (like `24h`) since which the searched records were recorded.")
+	bodyContains := searcher.String("body-contains", "", "If set, text the bodies of the searched records must contain.")
+	bodyRegex := searcher.String("body-regex", "", "If set, pattern the bodies of the searched records must match.")
+	kind := searcher.String("kind", "any", "Kind of the bodies searched: `request`, `response` or `any`.")
+	limit := searcher.Int("limit", 0, "If set, maximum number of records found.")
+	workers := searcher.Int("workers", runtime.NumCPU(), "Number of record files searched in parallel.")
+	searcher.Parse(os.Args[2:])
+
+	log.Printf("  method: %s", *method)
+	log.Printf("  path: %s", *path)
+	log.Printf("  since: %s", *since)
+	log.Printf("  body-contains: %s", *bodyContains)
+	log.Printf("  body-regex: %s", *bodyRegex)
+	log.Printf("  kind: %s", *kind)
+	log.Printf("  limit: %d", *limit)
+	log.Printf("  workers: %d", *workers)
+
+	if *method == "" && *path == "" && *since == "" && *bodyContains == "" && *bodyRegex == "" {
+		log.Fatal("At least one of --method, --path, --since, --body-contains or --body-regex is required.")
+	}
+	if *bodyContains != "" && *bodyRegex != "" {
+		log.Fatal("--body-contains and --body-regex cannot be used together.")
+	}
+	var pattern *regexp.Regexp
+	if *bodyContains != "" {
+		pattern = regexp.MustCompile(regexp.QuoteMeta(*bodyContains))
+	} else if *bodyRegex != "" {
+		var err error
+		if pattern, err = regexp.Compile(*bodyRegex); err != nil {
+			log.Fatalf("Invalid --body-regex: %s", err)
+		}
+	}
+	var methodPattern, pathPattern *regexp.Regexp
+	if *method != "" {
+		methodPattern = parseMethodFlag(*method)
+	}
+	if *path != "" {
+		var err error
+		if pathPattern, err = regexp.Compile(*path); err != nil {
+			log.Fatalf("Invalid --path: %s", err)
+		}
+	}
+	sinceDate, err := parseSince(*since, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *kind != "request" && *kind != "response" && *kind != "any" {
+		log.Fatalf("Unknown --kind `%s`, expected `request`, `response` or `any`.", *kind)
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	dirs := searcher.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	indexed := methodPattern != nil || pathPattern != nil || !sinceDate.IsZero()
+
+	files := make(chan string, *workers)
+	stop := make(chan struct{})
+	send := func(file string) error {
+		select {
+		case files <- file:
+			return nil
+		case <-stop:
+			return errSearchDone
+		}
+	}
+	go func() {
+		defer close(files)
+		for _, dir := range dirs {
+			if indexed {
+				candidates, err := searchCandidates(dir, methodPattern, pathPattern, sinceDate)
+				if err != nil {
+					log.Printf("Error while searching %s: %s", dir, err)
+					continue
+				}
+				for _, file := range candidates {
+					if send(file) != nil {
+						return
+					}
+				}
+				continue
+			}
+			err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() || !(isRecordFile(path, "request") || isRecordFile(path, "response") || isRecordFile(path, "pair")) {
+					return nil
+				}
+				return send(path)
+			})
+			if err == errSearchDone {
+				return
+			} else if err != nil {
+				log.Printf("Error while searching %s: %s", dir, err)
+			}
+		}
+	}()
+
+	found := 0
+	searchFiles(files, *workers, func(file string) (bool, error) {
+		if pattern == nil {
+			return true, nil
+		}
+		if isRecordFile(file, "skip") {
+			return false, nil
+		}
+		return searchRecordBodies(file, *kind, pattern)
+	}, func(file string) error {
+		fmt.Printf("%s\t%s\n", recordIDOfFile(file), file)
+		if found++; *limit > 0 && found >= *limit {
+			close(stop)
+			return errSearchDone
+		}
+		return nil
+	})
+	log.Printf("Found %d record(s).", found)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// secretDetector finds secrets matching a pattern, the secret being its
+// group when set, and having a minimum entropy in bits per character.
+type secretDetector struct {
+	pattern    *regexp.Regexp
+	group      int
+	minEntropy float64
+}
+
+var secretDetectors = map[string]secretDetector{
+	"aws-access-key": {regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), 0, 0},
+	"github-token":   {regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), 0, 0},
+	"google-api-key": {regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`), 0, 0},
+	"jwt":            {regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`), 0, 0},
+	"private-key":    {regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`), 0, 0},
+	"slack-token":    {regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), 0, 0},
+	"stripe-key":     {regexp.MustCompile(`\b[rs]k_(?:live|test)_[A-Za-z0-9]{16,}\b`), 0, 0},
+	"high-entropy":   {regexp.MustCompile(`(?i)(?:secret|token|passw(?:or)?d|api[_-]?key|access[_-]?key|private[_-]?key|client[_-]?secret)["']?\s*[:=]\s*["']?([^\s"'&,;]{12,})`), 1, 3.5},
+}
+
+var secretDetections = []string{"warn", "redact", "quarantine"}
+
+func checkSecretDetection(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, known := range secretDetections {
+		if mode == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown --detect-secrets `%s`, expected `warn`, `redact` or `quarantine`.", mode)
+}
+
+// entropy returns the Shannon entropy of a value in bits per character.
+func entropy(value string) float64 {
+	counts := map[rune]float64{}
+	length := 0.0
+	for _, r := range value {
+		counts[r]++
+		length++
+	}
+	bits := 0.0
+	for _, count := range counts {
+		p := count / length
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// findSecrets adds the kinds of secrets found in a value to kinds, redacting
+// them when redact is set.
+func findSecrets(value *string, redact bool, kinds map[string]bool) {
+	for kind, detector := range secretDetectors {
+		for _, match := range detector.pattern.FindAllStringSubmatchIndex(*value, -1) {
+			start, end := match[2*detector.group], match[2*detector.group+1]
+			if start < 0 || entropy((*value)[start:end]) < detector.minEntropy {
+				continue
+			}
+			kinds[kind] = true
+			if !redact {
+				continue
+			}
+			// Redacting changes offsets, the remaining matches are found
+			// again on the redacted value.
+			*value = (*value)[:start] + redactedString + (*value)[end:]
+			findSecrets(value, redact, kinds)
+			return
+		}
+	}
+}
+
+// detectSecrets looks for secrets in the headers and the text body of a
+// record and in the other given fields, recording their kinds in Secrets and
+// redacting them with --detect-secrets redact.
+func (ghr goHRec) detectSecrets(record *baseInfo, req string, fields ...*string) {
+	if ghr.secretDetection == "" {
+		return
+	}
+	for i := range record.Headers {
+		fields = append(fields, &record.Headers[i])
+	}
+	for i := range record.Trailers {
+		fields = append(fields, &record.Trailers[i])
+	}
+	if record.BodyEncoding == "" {
+		fields = append(fields, &record.Body)
+	}
+
+	kinds := map[string]bool{}
+	for _, field := range fields {
+		findSecrets(field, ghr.secretDetection == "redact", kinds)
+	}
+	if len(kinds) == 0 {
+		return
+	}
+	for kind := range kinds {
+		record.Secrets = append(record.Secrets, kind)
+	}
+	sort.Strings(record.Secrets)
+	metrics.Add("secrets_detected", 1)
+	log.Printf("Secrets detected: %s. (%s)", strings.Join(record.Secrets, ", "), req)
+}
+
+// quarantined returns the recorder saving a record, diverting it to the
+// quarantine directory when secrets were detected in it with
+// --detect-secrets quarantine.
+func (ghr goHRec) quarantined(secrets []string, req string) goHRec {
+	if ghr.secretDetection != "quarantine" || len(secrets) == 0 {
+		return ghr
+	}
+	metrics.Add("records_quarantined", 1)
+	log.Printf("Quarantined: secrets detected. (%s)", req)
+	ghr.quarantine = true
+	return ghr
+}
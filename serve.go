@@ -0,0 +1,511 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// stubResponse is a recorded response, its body being streamed from the
+// record file when served.
+type stubResponse struct {
+	file              string
+	bodyPath          []string
+	StatusCode        int
+	Headers           []string
+	TransferEncodings []string
+	DateUnixNano      int64
+	BodyEncoding      string
+}
+
+type stub struct {
+	verbose        bool
+	cacheHeaders   bool
+	strictFidelity bool
+	mutex          sync.Mutex
+	responses      map[string][]stubResponse
+	served         map[string]int
+}
+
+func stubKey(method, uri string) string {
+	return method + " " + uri
+}
+
+// loadStub indexes the responses of the records of a directory by the method
+// and URI of their request, without keeping their bodies in memory.
+func loadStub(dir string) (*stub, error) {
+	s := &stub{responses: map[string][]stubResponse{}, served: map[string]int{}}
+
+	requests := map[string]redoRecord{}
+	responses := map[string]stubResponse{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		var kinds []string
+		switch {
+		case isRecordFile(path, "request"):
+			kinds = []string{"request"}
+		case isRecordFile(path, "response"):
+			kinds = []string{"response"}
+		case isRecordFile(path, "pair"):
+			kinds = []string{"request", "response"}
+		default:
+			return nil
+		}
+		content, err := readRecordFile(path)
+		if err != nil {
+			return err
+		}
+		for _, kind := range kinds {
+			record := content
+			var bodyPath []string
+			if len(kinds) > 1 {
+				if record, err = recordOfPair(content, kind); err != nil || len(record) == 0 || string(record) == "null" {
+					continue
+				}
+				bodyPath = []string{"Response"}
+			}
+			var info struct {
+				ID                string
+				Method, URI       string
+				Headers           []string
+				TransferEncodings []string
+				StatusCode        int
+				DateUnixNano      int64
+				BodyEncoding      string
+			}
+			if err := json.Unmarshal(record, &info); err != nil {
+				log.Printf("Error while unmarshalling %s: %s", path, err)
+				return nil
+			}
+			if kind == "request" {
+				requests[info.ID] = redoRecord{Method: info.Method, URI: info.URI, DateUnixNano: info.DateUnixNano}
+			} else {
+				responses[info.ID] = stubResponse{file: path, bodyPath: bodyPath, StatusCode: info.StatusCode, Headers: info.Headers, TransferEncodings: info.TransferEncodings, DateUnixNano: info.DateUnixNano, BodyEncoding: info.BodyEncoding}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type pair struct {
+		request  redoRecord
+		response stubResponse
+	}
+	pairs := []pair{}
+	for id, request := range requests {
+		if response, ok := responses[id]; ok {
+			pairs = append(pairs, pair{request, response})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].request.DateUnixNano < pairs[j].request.DateUnixNano
+	})
+	for _, p := range pairs {
+		key := stubKey(p.request.Method, p.request.URI)
+		s.responses[key] = append(s.responses[key], p.response)
+	}
+	return s, nil
+}
+
+// next returns the recorded responses of a request in their recorded order,
+// the last one being repeated once all have been served.
+func (s *stub) next(r *http.Request) (stubResponse, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := stubKey(r.Method, r.URL.RequestURI())
+	responses, ok := s.responses[key]
+	if !ok || len(responses) == 0 {
+		return stubResponse{}, false
+	}
+	i := s.served[key]
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	s.served[key] = i + 1
+	return responses[i], true
+}
+
+// streamJSONBody finds the `Body` string of a JSON record, nested in the
+// objects of the specified keys if any, and writes it unescaped to w, without
+// loading it whole in memory.
+func streamJSONBody(ctx context.Context, r io.Reader, w io.Writer, parents ...string) (int64, error) {
+	reader := bufio.NewReader(r)
+	want := append(append([]string{}, parents...), "Body")
+	keys, objects := []string{}, []bool{}
+	inString, escaped, inKey, expectKey := false, false, false, false
+	var key bytes.Buffer
+
+	matches := func() bool {
+		if len(keys) != len(want) {
+			return false
+		}
+		for i := range want {
+			if keys[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+				if inKey {
+					keys[len(keys)-1] = key.String()
+					inKey = false
+				}
+				continue
+			}
+			if inKey && key.Len() < 64 {
+				key.WriteByte(c)
+			}
+			continue
+		}
+		switch c {
+		case '{', '[':
+			keys = append(keys, "")
+			objects = append(objects, c == '{')
+			expectKey = c == '{'
+		case '}', ']':
+			if len(keys) > 0 {
+				keys, objects = keys[:len(keys)-1], objects[:len(objects)-1]
+			}
+		case ',':
+			expectKey = len(objects) > 0 && objects[len(objects)-1]
+		case ':':
+			expectKey = false
+		case '"':
+			if !expectKey && matches() {
+				return unescapeJSONString(ctx, reader, w)
+			}
+			inString = true
+			inKey = expectKey
+			key.Reset()
+		}
+	}
+}
+
+func unescapeJSONString(ctx context.Context, reader *bufio.Reader, w io.Writer) (int64, error) {
+	writer := bufio.NewWriterSize(w, 32*1024)
+	var written int64
+	var pending rune = -1
+	flushRune := func(r rune) error {
+		var buf [utf8.UTFMax]byte
+		n := utf8.EncodeRune(buf[:], r)
+		written += int64(n)
+		_, err := writer.Write(buf[:n])
+		return err
+	}
+
+	for i := 0; ; i++ {
+		if i%(32*1024) == 0 {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+		}
+		c, err := reader.ReadByte()
+		if err != nil {
+			return written, err
+		}
+		if pending != -1 && c != '\\' {
+			if err := flushRune(utf8.RuneError); err != nil {
+				return written, err
+			}
+			pending = -1
+		}
+		switch c {
+		case '"':
+			if pending != -1 {
+				flushRune(utf8.RuneError)
+			}
+			return written, writer.Flush()
+		case '\\':
+			e, err := reader.ReadByte()
+			if err != nil {
+				return written, err
+			}
+			var r rune
+			switch e {
+			case 'b':
+				r = '\b'
+			case 'f':
+				r = '\f'
+			case 'n':
+				r = '\n'
+			case 'r':
+				r = '\r'
+			case 't':
+				r = '\t'
+			case 'u':
+				hex := make([]byte, 4)
+				if _, err := io.ReadFull(reader, hex); err != nil {
+					return written, err
+				}
+				code, err := strconv.ParseUint(string(hex), 16, 32)
+				if err != nil {
+					return written, err
+				}
+				r = rune(code)
+			default:
+				r = rune(e)
+			}
+			if pending != -1 {
+				if r >= 0xdc00 && r < 0xe000 {
+					r = (pending-0xd800)<<10 + (r - 0xdc00) + 0x10000
+				} else if err := flushRune(utf8.RuneError); err != nil {
+					return written, err
+				}
+				pending = -1
+			} else if r >= 0xd800 && r < 0xdc00 {
+				pending = r
+				continue
+			}
+			if err := flushRune(r); err != nil {
+				return written, err
+			}
+		default:
+			written++
+			if err := writer.WriteByte(c); err != nil {
+				return written, err
+			}
+		}
+	}
+}
+
+func (sr stubResponse) streamBody(ctx context.Context, w io.Writer) (int64, error) {
+	reader, err := openRecordFile(sr.file)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	switch sr.BodyEncoding {
+	case "":
+		return streamJSONBody(ctx, reader, w, sr.bodyPath...)
+	case "base64":
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := streamJSONBody(ctx, reader, pw, sr.bodyPath...)
+			pw.CloseWithError(err)
+		}()
+		n, err := io.Copy(w, base64.NewDecoder(base64.StdEncoding, pr))
+		pr.Close()
+		<-done
+		return n, err
+	default:
+		return 0, fmt.Errorf("unsupported body encoding `%s`", sr.BodyEncoding)
+	}
+}
+
+// chunked tells whether the response was recorded without Content-Length,
+// its body being sent in chunks.
+func (sr stubResponse) chunked() bool {
+	if findHeader(sr.Headers, "Content-Length") != "" {
+		return false
+	}
+	for _, encoding := range sr.TransferEncodings {
+		if strings.EqualFold(encoding, "chunked") {
+			return true
+		}
+	}
+	return false
+}
+
+// setConnectionHeaders reproduces the recorded Connection and Keep-Alive
+// behavior of a response, and prevents the headers it didn't have, like
+// Date and Content-Type, from being added.
+func (sr stubResponse) setConnectionHeaders(header http.Header) {
+	closing := false
+	for _, value := range strings.Split(findHeader(sr.Headers, "Connection"), ",") {
+		closing = closing || strings.EqualFold(strings.TrimSpace(value), "close")
+	}
+	if closing {
+		header.Set("Connection", "close")
+	} else if keepAlive := findHeader(sr.Headers, "Keep-Alive"); keepAlive != "" {
+		header.Set("Keep-Alive", keepAlive)
+	}
+	for _, name := range []string{"Date", "Content-Type"} {
+		if _, ok := header[name]; !ok {
+			header[name] = nil
+		}
+	}
+}
+
+func (s *stub) handler(w http.ResponseWriter, r *http.Request) {
+	req := makeRequestName(r)
+	response, ok := s.next(r)
+	if !ok {
+		if s.verbose {
+			log.Printf("Not found: no recorded response. (%s)", req)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "No recorded response.")
+		return
+	}
+
+	for _, header := range response.Headers {
+		split := strings.SplitN(header, ": ", 2)
+		if len(split) != 2 || hopByHopHeaders[split[0]] || split[0] == "Content-Length" {
+			continue
+		}
+		w.Header().Add(split[0], split[1])
+	}
+	if s.strictFidelity {
+		response.setConnectionHeaders(w.Header())
+		if response.chunked() {
+			s.serveChunked(w, r, response, req)
+			return
+		}
+	}
+
+	// A first pass computes the length of the body, so that the response has
+	// a correct Content-Length instead of being chunked.
+	hash := sha256.New()
+	sink := ioutil.Discard
+	if s.cacheHeaders {
+		sink = hash
+	}
+	length, err := response.streamBody(r.Context(), sink)
+	if err != nil && r.Context().Err() != nil {
+		if s.verbose {
+			log.Printf("Aborted: %s (%s)", err, req)
+		}
+		return
+	} else if err != nil {
+		log.Printf("Error while reading %s: %s", response.file, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if s.cacheHeaders {
+		setCacheHeaders(w.Header(), hash.Sum(nil), response.DateUnixNano)
+		if isNotModified(r, w.Header()) {
+			w.WriteHeader(http.StatusNotModified)
+			if s.verbose {
+				log.Printf("Not modified: %s (%s)", response.file, req)
+			}
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(response.StatusCode)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := response.streamBody(r.Context(), w); err != nil {
+		if s.verbose {
+			log.Printf("Aborted: %s (%s)", err, req)
+		}
+		return
+	}
+	if s.verbose {
+		log.Printf("Served: %s (%s)", response.file, req)
+	}
+}
+
+// serveChunked serves a response recorded without Content-Length in chunks,
+// its headers being flushed before its body.
+func (s *stub) serveChunked(w http.ResponseWriter, r *http.Request, response stubResponse, req string) {
+	w.WriteHeader(response.StatusCode)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	if _, err := response.streamBody(r.Context(), w); err != nil {
+		if s.verbose {
+			log.Printf("Aborted: %s (%s)", err, req)
+		}
+		return
+	}
+	if s.verbose {
+		log.Printf("Served: %s (%s)", response.file, req)
+	}
+}
+
+func serve() {
+	server := flag.NewFlagSet("serve", flag.PanicOnError)
+	listen := server.String("listen", ":8080", "Interface and port to listen.")
+	profileName := server.String("profile", "", "If set, preset of options for a common scenario: `mock-server`, options set explicitly overriding it.")
+	dir := server.String("dir", ".", "Directory of the request and response records to serve.")
+	verbose := server.Bool("verbose", false, "Log served request status.")
+	cacheHeaders := server.Bool("cache-headers", false, "Add ETag and Last-Modified headers derived from records when missing, and answer conditional requests with 304.")
+	strictFidelity := server.Bool("strict-fidelity", false, "Serve exactly the recorded response headers, without adding Date nor Content-Type, reproducing recorded Connection: close, Keep-Alive and chunked bodies.")
+	args, err := expandProfile("serve", os.Args[2:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.Parse(args)
+
+	log.Printf("  profile: %s", *profileName)
+	log.Printf("  cache-headers: %t", *cacheHeaders)
+	log.Printf("  strict-fidelity: %t", *strictFidelity)
+	log.Printf("  listen: %s", *listen)
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  verbose: %t", *verbose)
+
+	s, err := loadStub(*dir)
+	if err != nil {
+		log.Fatalf("Error while loading records: %s", err)
+	}
+	s.verbose = *verbose
+	s.cacheHeaders = *cacheHeaders
+	s.strictFidelity = *strictFidelity
+	log.Printf("Loaded %d recorded endpoint(s).", len(s.responses))
+
+	log.Fatal(http.ListenAndServe(*listen, http.HandlerFunc(s.handler)))
+}
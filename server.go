@@ -0,0 +1,70 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs the server until SIGINT or SIGTERM is received, then stops
+// accepting connections and waits for in-flight requests, and the records
+// they produce, to complete, up to the shutdown timeout.
+func (ghr goHRec) serve(server *http.Server, shutdownTimeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %s, shutting down...", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error while shutting down: %s", err)
+		}
+		close(done)
+	}()
+
+	if err := ghr.listenAndServe(server); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	ghr.canary.wait(shutdownTimeout)
+
+	if ghr.indexFile != nil {
+		ghr.indexMutex.Lock()
+		if err := ghr.indexFile.Sync(); err != nil {
+			log.Printf("Error while flushing index: %s", err)
+		}
+		ghr.indexMutex.Unlock()
+	}
+	ghr.manifest.write(ghr.dateFormat, ghr.writeFile, ghr.logError)
+	ghr.captureSessions.closeAll(ghr.dateFormat, ghr.writeFile, ghr.logError)
+	log.Print("Stopped.")
+}
+
+// listenAndServe serves like server.ListenAndServe, capturing the requests
+// the server cannot parse when --record-malformed is set.
+func (ghr goHRec) listenAndServe(server *http.Server) error {
+	if !ghr.captureMalformed {
+		return server.ListenAndServe()
+	}
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(malformedListener{Listener: listener, ghr: ghr})
+}
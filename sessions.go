@@ -0,0 +1,126 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionKey derives a session from `cookie:<name>` or `header:<name>`.
+type sessionKey struct {
+	cookie bool
+	name   string
+}
+
+func makeSessionKey(value string) (*sessionKey, error) {
+	switch {
+	case value == "":
+		return nil, nil
+	case strings.HasPrefix(value, "cookie:"):
+		return &sessionKey{cookie: true, name: strings.TrimPrefix(value, "cookie:")}, nil
+	case strings.HasPrefix(value, "header:"):
+		return &sessionKey{name: strings.TrimPrefix(value, "header:")}, nil
+	}
+	return nil, fmt.Errorf("Invalid session key `%s`, expected `cookie:<name>` or `header:<name>`.", value)
+}
+
+// sessionID returns a hash of the session key value, so that the record does
+// not disclose the session token itself.
+func (sk *sessionKey) sessionID(r *http.Request) string {
+	if sk == nil {
+		return ""
+	}
+	value := ""
+	if sk.cookie {
+		if cookie, err := r.Cookie(sk.name); err == nil {
+			value = cookie.Value
+		}
+	} else {
+		value = r.Header.Get(sk.name)
+	}
+	if value == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:12])
+}
+
+type sessionSummary struct {
+	SessionID   string
+	Exchanges   int
+	First, Last time.Time
+	Duration    time.Duration
+}
+
+func summarizeSessions(records []exportRecord) []sessionSummary {
+	sessions := map[string]*sessionSummary{}
+	for _, record := range records {
+		if record.SessionID == "" {
+			continue
+		}
+		session, ok := sessions[record.SessionID]
+		if !ok {
+			session = &sessionSummary{SessionID: record.SessionID, First: record.DateUTC, Last: record.DateUTC}
+			sessions[record.SessionID] = session
+		}
+		if record.kind == "request" {
+			session.Exchanges++
+		}
+		if record.DateUTC.Before(session.First) {
+			session.First = record.DateUTC
+		}
+		if record.DateUTC.After(session.Last) {
+			session.Last = record.DateUTC
+		}
+	}
+
+	out := []sessionSummary{}
+	for _, session := range sessions {
+		session.Duration = session.Last.Sub(session.First)
+		out = append(out, *session)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].First.Before(out[j].First)
+	})
+	return out
+}
+
+func listSessions() {
+	lister := flag.NewFlagSet("sessions", flag.PanicOnError)
+	dir := lister.String("dir", ".", "Directory of the records.")
+	format := lister.String("format", "text", "Output format: `text` or `json`.")
+	lister.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  format: %s", *format)
+
+	records, err := loadExportRecords(*dir, "request", "response")
+	if err != nil {
+		log.Fatalf("Error while loading records: %s", err)
+	}
+	sessions := summarizeSessions(records)
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", " ")
+		encoder.Encode(sessions)
+		return
+	}
+	fmt.Printf("%-24s\t%9s\t%-30s\t%s\n", "SESSION", "EXCHANGES", "FIRST", "DURATION")
+	for _, session := range sessions {
+		fmt.Printf("%-24s\t%9d\t%-30s\t%s\n", session.SessionID, session.Exchanges, session.First.Format(time.RFC3339Nano), session.Duration)
+	}
+	log.Printf("Found %d session(s).", len(sessions))
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// signatureVerifier checks HMAC signatures of webhook bodies, like GitHub's
+// `X-Hub-Signature-256: sha256=<hex>`.
+type signatureVerifier struct {
+	header string
+	alg    string
+	secret []byte
+	reject bool
+}
+
+// makeSignatureVerifier parses a `header=...,alg=...,secret-file=...[,reject=true]`
+// specification.
+func makeSignatureVerifier(spec string) (*signatureVerifier, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	sv := &signatureVerifier{alg: "hmac-sha256"}
+	for _, option := range strings.Split(spec, ",") {
+		split := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("Invalid --verify-signature option `%s`, expected `key=value`.", option)
+		}
+		switch key, value := split[0], split[1]; key {
+		case "header":
+			sv.header = http.CanonicalHeaderKey(value)
+		case "alg":
+			if _, ok := signatureAlgorithms[value]; !ok {
+				return nil, fmt.Errorf("Unsupported --verify-signature alg `%s`, expected `hmac-sha1`, `hmac-sha256` or `hmac-sha512`.", value)
+			}
+			sv.alg = value
+		case "secret-file":
+			secret, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error while reading --verify-signature secret: %s", err)
+			}
+			sv.secret = bytes.TrimRight(secret, "\r\n")
+		case "reject":
+			reject, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid --verify-signature reject `%s`: %s", value, err)
+			}
+			sv.reject = reject
+		default:
+			return nil, fmt.Errorf("Unknown --verify-signature option `%s`.", key)
+		}
+	}
+	if sv.header == "" || sv.secret == nil {
+		return nil, fmt.Errorf("--verify-signature requires `header` and `secret-file`.")
+	}
+	return sv, nil
+}
+
+// verify returns `valid`, `invalid` or `missing`. Signatures may be prefixed
+// by their algorithm (like `sha256=`) and encoded in hex or base64.
+func (sv *signatureVerifier) verify(r *http.Request, body []byte) string {
+	value := strings.TrimSpace(r.Header.Get(sv.header))
+	if value == "" {
+		return "missing"
+	}
+	if i := strings.Index(value, "="); i > -1 && strings.HasPrefix(sv.alg, "hmac-") && value[:i] == strings.TrimPrefix(sv.alg, "hmac-") {
+		value = value[i+1:]
+	}
+
+	mac := hmac.New(signatureAlgorithms[sv.alg], sv.secret)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	if signature, err := hex.DecodeString(value); err == nil && hmac.Equal(signature, expected) {
+		return "valid"
+	}
+	if signature, err := base64.StdEncoding.DecodeString(value); err == nil && hmac.Equal(signature, expected) {
+		return "valid"
+	}
+	return "invalid"
+}
+
+// resigner recomputes the HMAC signature header of a redone request over its
+// possibly modified body.
+type resigner struct {
+	header string
+	alg    string
+	secret []byte
+}
+
+// makeResigner parses a `Header=alg:secret` specification.
+func makeResigner(spec string) (*resigner, error) {
+	split := strings.SplitN(spec, "=", 2)
+	if len(split) != 2 || split[0] == "" {
+		return nil, fmt.Errorf("Invalid --resign `%s`, expected `Header=alg:secret`.", spec)
+	}
+	key := strings.SplitN(split[1], ":", 2)
+	if len(key) != 2 {
+		return nil, fmt.Errorf("Invalid --resign `%s`, expected `Header=alg:secret`.", spec)
+	}
+	if _, ok := signatureAlgorithms[key[0]]; !ok {
+		return nil, fmt.Errorf("Unsupported --resign alg `%s`, expected `hmac-sha1`, `hmac-sha256` or `hmac-sha512`.", key[0])
+	}
+	return &resigner{header: http.CanonicalHeaderKey(split[0]), alg: key[0], secret: []byte(key[1])}, nil
+}
+
+// sign returns the signature of body formatted like previous, the recorded
+// value: with or without its algorithm prefix (like `sha256=`), in hex or
+// base64.
+func (rs *resigner) sign(body []byte, previous string) string {
+	mac := hmac.New(signatureAlgorithms[rs.alg], rs.secret)
+	mac.Write(body)
+	signature := mac.Sum(nil)
+
+	prefix := ""
+	if p := strings.TrimPrefix(rs.alg, "hmac-") + "="; strings.HasPrefix(previous, p) {
+		prefix, previous = p, strings.TrimPrefix(previous, p)
+	}
+	if _, err := hex.DecodeString(previous); previous != "" && err != nil {
+		return prefix + base64.StdEncoding.EncodeToString(signature)
+	}
+	return prefix + hex.EncodeToString(signature)
+}
+
+// apply replaces the signature header of record, adding it when missing.
+func (rs *resigner) apply(record *redoRecord) {
+	headers := []string{}
+	previous := ""
+	for _, header := range record.Headers {
+		split := strings.SplitN(header, ": ", 2)
+		if len(split) == 2 && http.CanonicalHeaderKey(split[0]) == rs.header {
+			previous = strings.TrimSpace(split[1])
+			continue
+		}
+		headers = append(headers, header)
+	}
+	record.Headers = append(headers, rs.header+": "+rs.sign([]byte(record.Body), previous))
+}
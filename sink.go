@@ -0,0 +1,124 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sinkRecord is a serialized record published to a sink.
+type sinkRecord struct {
+	ID, Kind, Name string
+	Date           time.Time
+	Content        []byte
+}
+
+// recordSink publishes records somewhere else than the filesystem.
+type recordSink interface {
+	publish(record sinkRecord) error
+	close() error
+}
+
+// sinkOptions are the options of the sinks other than `file`.
+type sinkOptions struct {
+	kafkaBrokers, kafkaTopic string
+	esURL, esIndex           string
+	syslogURL, gelfURL       string
+	logSinkContent           string
+	store, storeFile         string
+	via                      string
+	logError                 errorLogger
+}
+
+// makeSinks parses a comma-separated list of sinks, telling whether records
+// are written to the filesystem too.
+func makeSinks(spec string, options sinkOptions) ([]recordSink, bool, error) {
+	sinks := []recordSink{}
+	file := false
+	for _, name := range strings.Split(spec, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "file":
+			if options.store == "" {
+				file = true
+				continue
+			}
+			store, err := makeStore(options.store, options.storeFile)
+			if err != nil {
+				return nil, false, err
+			}
+			sinks = append(sinks, store)
+		case "kafka":
+			sink, err := makeKafkaSink(options.kafkaBrokers, options.kafkaTopic)
+			if err != nil {
+				return nil, false, err
+			}
+			sinks = append(sinks, sink)
+		case "elasticsearch":
+			sink, err := makeESSink(options.esURL, options.esIndex, options.via, options.logError)
+			if err != nil {
+				return nil, false, err
+			}
+			sinks = append(sinks, sink)
+		case "syslog":
+			sink, err := makeSyslogSink(options.syslogURL, options.logSinkContent)
+			if err != nil {
+				return nil, false, err
+			}
+			sinks = append(sinks, sink)
+		case "gelf":
+			sink, err := makeGELFSink(options.gelfURL, options.logSinkContent)
+			if err != nil {
+				return nil, false, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, false, fmt.Errorf("Unknown --sink `%s`, expected `file`, `kafka`, `elasticsearch`, `syslog` or `gelf`.", name)
+		}
+	}
+	return sinks, file, nil
+}
+
+// makeStore returns the backend replacing the filesystem for a store, file
+// being the pattern of the files of the ndjson one.
+func makeStore(raw, file string) (recordSink, error) {
+	switch {
+	case raw == "ndjson":
+		return makeNDJSONStore(file)
+	case raw == "stdout":
+		return &stdoutStore{}, nil
+	case strings.HasPrefix(raw, "postgres://") || strings.HasPrefix(raw, "postgresql://"):
+		return makePGStore(raw)
+	}
+	return nil, fmt.Errorf("Unknown --store `%s`, expected `ndjson`, `stdout` or `postgres://...`.", redactedURL(raw))
+}
+
+// publish sends a record to all sinks.
+func (ghr goHRec) publish(record sinkRecord) {
+	for _, sink := range ghr.sinks {
+		if err := sink.publish(record); err != nil {
+			metrics.Add("records_failed", 1)
+			ghr.logError(errorStorage, "Error while publishing record", err, "file", record.Name)
+		}
+	}
+}
+
+func (ghr goHRec) closeSinks() {
+	for _, sink := range ghr.sinks {
+		if err := sink.close(); err != nil {
+			ghr.logError(errorStorage, "Error while closing sink", err)
+		}
+	}
+}
+
+// redactedURL hides the password of a sink URL to log it.
+func redactedURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
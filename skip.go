@@ -0,0 +1,55 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// skipRecord is the summary recorded for a skipped request, without any
+// header or body. Its reason is `filtered`, `loop`, `no-route`,
+// `rate-limited`, `sampled-out`, `too-many-connections` or `too-large`.
+type skipRecord struct {
+	ID                 string
+	Date, DateUTC      time.Time
+	DateUnixNano       int64
+	Reason             string
+	RemoteAddr         string
+	PeerAddr           string `json:",omitempty"`
+	Host, Method, Path string
+	ContentLength      int64
+}
+
+// recordSkip saves a summary of a skipped request when --record-skips is set.
+func (ghr goHRec) recordSkip(r *http.Request, reason string) {
+	if ghr.recordSkips != "summary" {
+		return
+	}
+
+	received := time.Now()
+	req := makeRequestName(r)
+	record := skipRecord{
+		ID:            ghr.makeRequestID(req, received),
+		Date:          received,
+		DateUTC:       received.UTC(),
+		DateUnixNano:  received.UnixNano(),
+		Reason:        reason,
+		RemoteAddr:    ghr.trustedProxies.clientAddr(r),
+		PeerAddr:      ghr.peerAddr(r),
+		Host:          r.Host,
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		ContentLength: r.ContentLength,
+	}
+
+	json, err := json.MarshalIndent(record, "", " ")
+	if err != nil {
+		ghr.logError(errorStorage, "Error while serializing record", err, "request", req)
+		return
+	}
+	ghr.saveJSON(json, record.ID, received, "skip", req)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slaBudget is the latency and status budget of the exchanges whose method
+// and path match.
+type slaBudget struct {
+	raw      string
+	method   string
+	path     *regexp.Regexp
+	latency  time.Duration
+	statuses map[string]bool
+}
+
+// slaEvaluation is the outcome of the evaluation of an exchange against its
+// budget, saved in its response record.
+type slaEvaluation struct {
+	Budget     string
+	Violated   bool
+	Violations []string `json:",omitempty"`
+}
+
+// arraySLAFlag holds budgets formatted as `[<method> ]<path regexp>=<rule>[,<rule>...]`,
+// rules being `latency:<duration>` and `status:<code|class>[|...]`, the first
+// matching budget applying.
+type arraySLAFlag []*slaBudget
+
+func (asf *arraySLAFlag) String() string {
+	raws := []string{}
+	for _, budget := range *asf {
+		raws = append(raws, "`"+budget.raw+"`")
+	}
+	return "[ " + strings.Join(raws, ", ") + " ]"
+}
+
+func (asf *arraySLAFlag) Set(value string) error {
+	split := strings.SplitN(value, "=", 2)
+	if len(split) != 2 {
+		return fmt.Errorf("Invalid --sla `%s`, expected `[<method> ]<path regexp>=<rule>[,<rule>...]`.", value)
+	}
+	budget := &slaBudget{raw: value, statuses: map[string]bool{}}
+	pattern := strings.TrimSpace(split[0])
+	if fields := strings.SplitN(pattern, " ", 2); len(fields) == 2 {
+		budget.method, pattern = strings.ToUpper(fields[0]), strings.TrimSpace(fields[1])
+	}
+	path, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid --sla path `%s`: %s", pattern, err)
+	}
+	budget.path = path
+
+	for _, rule := range strings.Split(split[1], ",") {
+		option := strings.SplitN(strings.TrimSpace(rule), ":", 2)
+		if len(option) != 2 {
+			return fmt.Errorf("Invalid --sla rule `%s`, expected `latency:<duration>` or `status:<code|class>[|...]`.", rule)
+		}
+		switch option[0] {
+		case "latency":
+			if budget.latency, err = time.ParseDuration(option[1]); err != nil || budget.latency <= 0 {
+				return fmt.Errorf("Invalid --sla latency `%s`, expected a duration like `300ms`.", option[1])
+			}
+		case "status":
+			for _, status := range strings.Split(option[1], "|") {
+				status = strings.ToLower(strings.TrimSpace(status))
+				if code, err := strconv.Atoi(status); (err != nil || code < 100 || code > 599) && !(len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5') {
+					return fmt.Errorf("Invalid --sla status `%s`, expected a code (like `200`) or a class (like `2xx`).", status)
+				}
+				budget.statuses[status] = true
+			}
+		default:
+			return fmt.Errorf("Unknown --sla rule `%s`, expected `latency` or `status`.", option[0])
+		}
+	}
+	*asf = append(*asf, budget)
+	return nil
+}
+
+// Load reads budgets from a file, one per line, ignoring empty lines and
+// comments starting with `#`.
+func (asf *arraySLAFlag) Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := asf.Set(text); err != nil {
+			return fmt.Errorf("%s:%d: %s", file, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// evaluate checks an exchange against the first matching budget, counting
+// the violations, nil when no budget matches.
+func (asf arraySLAFlag) evaluate(method, path string, statusCode int, latency time.Duration) *slaEvaluation {
+	for _, budget := range asf {
+		if (budget.method != "" && budget.method != method) || !budget.path.MatchString(path) {
+			continue
+		}
+		evaluation := &slaEvaluation{Budget: budget.raw}
+		if budget.latency > 0 && latency > budget.latency {
+			evaluation.Violations = append(evaluation.Violations, fmt.Sprintf("latency: %s > %s", latency.Round(time.Microsecond), budget.latency))
+			metrics.Add("sla_violations_latency", 1)
+		}
+		code := strconv.Itoa(statusCode)
+		if len(budget.statuses) > 0 && !budget.statuses[code] && !budget.statuses[code[:1]+"xx"] {
+			evaluation.Violations = append(evaluation.Violations, "status: "+code)
+			metrics.Add("sla_violations_status", 1)
+		}
+		evaluation.Violated = len(evaluation.Violations) > 0
+		metrics.Add("sla_checked", 1)
+		if evaluation.Violated {
+			metrics.Add("sla_violations", 1)
+		}
+		return evaluation
+	}
+	return nil
+}
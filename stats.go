@@ -0,0 +1,137 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// statsPercentiles are the percentiles of a distribution.
+type statsPercentiles struct {
+	P50, P90, P95, P99, Max float64
+}
+
+func percentiles(values []float64) *statsPercentiles {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	at := func(p float64) float64 {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return &statsPercentiles{P50: at(.5), P90: at(.9), P95: at(.95), P99: at(.99), Max: sorted[len(sorted)-1]}
+}
+
+// trafficStats summarizes recorded exchanges.
+type trafficStats struct {
+	Exchanges, Unanswered int
+	Methods               []reportCount
+	Endpoints             []reportCount
+	Statuses              []reportCount
+	RequestBodyBytes      *statsPercentiles `json:",omitempty"`
+	ResponseBodyBytes     *statsPercentiles `json:",omitempty"`
+	LatencyMilliseconds   *statsPercentiles `json:",omitempty"`
+}
+
+func makeTrafficStats(exchanges []exchange, top int) trafficStats {
+	stats := trafficStats{Exchanges: len(exchanges)}
+	methods, endpoints, statuses := map[string]int{}, map[string]int{}, map[string]int{}
+	requestSizes, responseSizes, latencies := []float64{}, []float64{}, []float64{}
+	for _, ex := range exchanges {
+		path, _ := templatePath(ex.Request.Path)
+		methods[ex.Request.Method]++
+		endpoints[ex.Request.Method+" "+path]++
+		requestSizes = append(requestSizes, float64(len(ex.Request.Body)))
+		if ex.Response == nil {
+			stats.Unanswered++
+			statuses["none"]++
+			continue
+		}
+		statuses[strconv.Itoa(ex.Response.StatusCode)]++
+		responseSizes = append(responseSizes, float64(len(ex.Response.Body)))
+		latencies = append(latencies, float64(ex.Duration)/float64(time.Millisecond))
+	}
+	stats.Methods = sortedCounts(methods, 0)
+	stats.Endpoints = sortedCounts(endpoints, top)
+	stats.Statuses = sortedCounts(statuses, 0)
+	stats.RequestBodyBytes = percentiles(requestSizes)
+	stats.ResponseBodyBytes = percentiles(responseSizes)
+	stats.LatencyMilliseconds = percentiles(latencies)
+	return stats
+}
+
+func (stats trafficStats) writeTable(out io.Writer) error {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Exchanges\t%d\n", stats.Exchanges)
+	fmt.Fprintf(writer, "Unanswered\t%d\n", stats.Unanswered)
+	for _, section := range []struct {
+		title  string
+		counts []reportCount
+	}{{"Method", stats.Methods}, {"Endpoint", stats.Endpoints}, {"Status", stats.Statuses}} {
+		fmt.Fprintf(writer, "\n%s\tCount\n", section.title)
+		for _, count := range section.counts {
+			fmt.Fprintf(writer, "%s\t%d\n", count.Name, count.Count)
+		}
+	}
+	fmt.Fprintf(writer, "\n\tp50\tp90\tp95\tp99\tmax\n")
+	for _, distribution := range []struct {
+		title, verb string
+		percentiles *statsPercentiles
+	}{{"Request body (bytes)", "%.0f", stats.RequestBodyBytes}, {"Response body (bytes)", "%.0f", stats.ResponseBodyBytes}, {"Latency (ms)", "%.1f", stats.LatencyMilliseconds}} {
+		if p := distribution.percentiles; p != nil {
+			fmt.Fprint(writer, distribution.title)
+			for _, value := range []float64{p.P50, p.P90, p.P95, p.P99, p.Max} {
+				fmt.Fprintf(writer, "\t"+distribution.verb, value)
+			}
+			fmt.Fprintln(writer)
+		}
+	}
+	return writer.Flush()
+}
+
+func stats() {
+	statser := flag.NewFlagSet("stats", flag.PanicOnError)
+	dir := statser.String("dir", ".", "Directory of the records.")
+	format := statser.String("format", "table", "Output format: `table` or `json`.")
+	top := statser.Int("top", 20, "Maximum number of endpoints listed, `0` for all.")
+	statser.Parse(os.Args[2:])
+
+	log.Printf("  dir: %s", *dir)
+	log.Printf("  format: %s", *format)
+	log.Printf("  top: %d", *top)
+
+	if *format != "table" && *format != "json" {
+		log.Fatalf("Unknown --format `%s`, expected `table` or `json`.", *format)
+	}
+
+	records, err := loadExportRecords(*dir, "request", "response")
+	if err != nil {
+		log.Fatalf("Error while loading records: %s", err)
+	}
+	stats := makeTrafficStats(joinExchanges(records), *top)
+
+	if *format == "json" {
+		content, err := json.MarshalIndent(stats, "", " ")
+		if err != nil {
+			log.Fatalf("Error while serializing stats: %s", err)
+		}
+		fmt.Println(string(content))
+		return
+	}
+	if err := stats.writeTable(os.Stdout); err != nil {
+		log.Fatalf("Error while writing stats: %s", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// storageUsage is the number of files and bytes on disk of records.
+type storageUsage struct {
+	Files int
+	Bytes int64
+}
+
+func (su *storageUsage) add(size int64) {
+	su.Files++
+	su.Bytes += size
+}
+
+// storageStats reports the records on disk, per kind, day of their last
+// modification and top-level directory, and the headroom left by
+// --retention and --max-disk-usage.
+type storageStats struct {
+	Records                int
+	Total                  storageUsage
+	Kinds                  map[string]*storageUsage
+	Days                   map[string]*storageUsage
+	Partitions             map[string]*storageUsage
+	Oldest, Newest         *time.Time `json:",omitempty"`
+	RetentionSeconds       float64    `json:",omitempty"`
+	OldestExpiresInSeconds float64    `json:",omitempty"`
+	MaxDiskUsage           int64      `json:",omitempty"`
+	DiskHeadroom           int64      `json:",omitempty"`
+	DiskUsagePercent       float64    `json:",omitempty"`
+}
+
+// computeStorageStats scans the record files of dir.
+func (ghr goHRec) computeStorageStats(dir string, now time.Time) (storageStats, error) {
+	stats := storageStats{
+		Kinds:      map[string]*storageUsage{},
+		Days:       map[string]*storageUsage{},
+		Partitions: map[string]*storageUsage{},
+	}
+	files, err := listRecordFiles(dir)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, file := range files {
+		kind := "annotations"
+		for _, k := range []string{"request", "response", "pair", "skip"} {
+			if isRecordFile(file.path, k) {
+				kind = k
+				break
+			}
+		}
+		if kind == "request" || kind == "pair" || kind == "skip" {
+			stats.Records++
+		}
+		partition := "."
+		if rel, err := filepath.Rel(dir, file.path); err == nil && strings.Contains(filepath.ToSlash(rel), "/") {
+			partition = strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		}
+		addUsage(stats.Kinds, kind, file.size)
+		addUsage(stats.Days, file.modTime.UTC().Format("2006-01-02"), file.size)
+		addUsage(stats.Partitions, partition, file.size)
+		stats.Total.add(file.size)
+	}
+
+	if len(files) > 0 {
+		// Files are sorted by modification time.
+		oldest, newest := files[0].modTime.UTC(), files[len(files)-1].modTime.UTC()
+		stats.Oldest, stats.Newest = &oldest, &newest
+		if ghr.retention > 0 {
+			stats.OldestExpiresInSeconds = oldest.Add(ghr.retention).Sub(now).Seconds()
+		}
+	}
+	stats.RetentionSeconds = ghr.retention.Seconds()
+	if ghr.maxDiskUsage > 0 {
+		stats.MaxDiskUsage = ghr.maxDiskUsage
+		stats.DiskHeadroom = ghr.maxDiskUsage - stats.Total.Bytes
+		stats.DiskUsagePercent = float64(stats.Total.Bytes) * 100 / float64(ghr.maxDiskUsage)
+	}
+	return stats, nil
+}
+
+func addUsage(usages map[string]*storageUsage, key string, size int64) {
+	usage, ok := usages[key]
+	if !ok {
+		usage = &storageUsage{}
+		usages[key] = usage
+	}
+	usage.add(size)
+}
+
+// storageStatsHandler reports with GET the storage statistics of records,
+// authenticated like the admin API.
+func (ghr goHRec) storageStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := ghr.computeStorageStats(".", time.Now())
+	if err != nil {
+		ghr.logError(errorStorage, "Error while listing records", err)
+		http.Error(w, "Error while listing records.", http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, stats)
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logSinkDialTimeout = 10 * time.Second
+	gelfChunkSize      = 8192
+	gelfMaxChunks      = 128
+	syslogPriority     = 16*8 + 6 // local0.info
+)
+
+// recordSummary holds the metadata of a record forwarded by log sinks.
+type recordSummary struct {
+	ID, Tenant, SessionID, CorrelationID string
+	RemoteAddr, Host, Method, URI        string
+	StatusCode                           int
+	ContentLength                        int64
+	Secrets                              []string
+}
+
+// summaryFields returns the non-empty metadata of a record, by name.
+func summaryFields(record sinkRecord) ([][2]string, error) {
+	var summary recordSummary
+	if err := json.Unmarshal(record.Content, &summary); err != nil {
+		return nil, err
+	}
+	fields := [][2]string{{"id", record.ID}, {"kind", record.Kind}}
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{name, value})
+		}
+	}
+	add("tenant", summary.Tenant)
+	add("session_id", summary.SessionID)
+	add("correlation_id", summary.CorrelationID)
+	add("remote_addr", summary.RemoteAddr)
+	add("host", summary.Host)
+	add("method", summary.Method)
+	add("uri", summary.URI)
+	if summary.StatusCode != 0 {
+		add("status", strconv.Itoa(summary.StatusCode))
+	}
+	add("content_length", strconv.FormatInt(summary.ContentLength, 10))
+	add("secrets", strings.Join(summary.Secrets, ","))
+	add("file", record.Name)
+	return fields, nil
+}
+
+// summaryMessage returns a one-line description of a record.
+func summaryMessage(record sinkRecord, fields [][2]string) string {
+	message := []string{"Recorded " + record.Kind}
+	for _, field := range fields {
+		switch field[0] {
+		case "method", "uri", "status":
+			message = append(message, field[1])
+		}
+	}
+	return strings.Join(message, " ")
+}
+
+// logSink forwards records as messages to a log server over UDP or TCP,
+// reconnecting once when a TCP connection is broken.
+type logSink struct {
+	network, addr string
+	full          bool
+	format        func(record sinkRecord, fields [][2]string, full bool) ([]byte, error)
+	frame         func(message []byte) [][]byte
+	mutex         sync.Mutex
+	conn          net.Conn
+}
+
+func makeLogSink(name, raw, content string, defaultPort string) (*logSink, error) {
+	u, err := url.Parse(raw)
+	if raw == "" || err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Hostname() == "" {
+		return nil, fmt.Errorf("--sink %s requires --%s-url, like `udp://host:%s` or `tcp://host:%s`.", name, name, defaultPort, defaultPort)
+	}
+	if content != "summary" && content != "full" {
+		return nil, fmt.Errorf("Unknown --log-sink-content `%s`, expected `summary` or `full`.", content)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	ls := &logSink{network: u.Scheme, addr: addr, full: content == "full"}
+	if err := ls.connect(); err != nil {
+		return nil, fmt.Errorf("Error while connecting to %s: %s", addr, err)
+	}
+	return ls, nil
+}
+
+func (ls *logSink) connect() (err error) {
+	ls.conn, err = net.DialTimeout(ls.network, ls.addr, logSinkDialTimeout)
+	return err
+}
+
+func (ls *logSink) publish(record sinkRecord) error {
+	fields, err := summaryFields(record)
+	if err != nil {
+		return err
+	}
+	message, err := ls.format(record, fields, ls.full)
+	if err != nil {
+		return err
+	}
+	frames := ls.frame(message)
+	if frames == nil {
+		return fmt.Errorf("message of %d bytes too large", len(message))
+	}
+
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	write := func() error {
+		if ls.conn == nil {
+			if err := ls.connect(); err != nil {
+				return err
+			}
+		}
+		for _, frame := range frames {
+			if _, err := ls.conn.Write(frame); err != nil {
+				ls.conn.Close()
+				ls.conn = nil
+				return err
+			}
+		}
+		return nil
+	}
+	if err = write(); err != nil && ls.network == "tcp" {
+		err = write()
+	}
+	return err
+}
+
+func (ls *logSink) close() error {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if ls.conn == nil {
+		return nil
+	}
+	err := ls.conn.Close()
+	ls.conn = nil
+	return err
+}
+
+// makeSyslogSink forwards records as RFC 5424 messages, their metadata being
+// structured data, framed by octet counting over TCP.
+func makeSyslogSink(raw, content string) (*logSink, error) {
+	ls, err := makeLogSink("syslog", raw, content, "514")
+	if err != nil {
+		return nil, err
+	}
+	ls.format = formatSyslog
+	ls.frame = func(message []byte) [][]byte {
+		if ls.network == "tcp" {
+			return [][]byte{append([]byte(strconv.Itoa(len(message))+" "), message...)}
+		}
+		return [][]byte{message}
+	}
+	return ls, nil
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func formatSyslog(record sinkRecord, fields [][2]string, full bool) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "<%d>1 %s %s gohrec %d %s [gohrec@32473", syslogPriority, record.Date.UTC().Format(time.RFC3339Nano), hostname, os.Getpid(), record.Kind)
+	for _, field := range fields {
+		fmt.Fprintf(&message, ` %s="%s"`, field[0], syslogParamEscaper.Replace(field[1]))
+	}
+	message.WriteString("] ")
+	if full {
+		if err := json.Compact(&message, record.Content); err != nil {
+			return nil, err
+		}
+	} else {
+		message.WriteString(summaryMessage(record, fields))
+	}
+	return message.Bytes(), nil
+}
+
+// makeGELFSink forwards records as GELF 1.1 messages, their metadata being
+// additional fields, chunked over UDP and null-terminated over TCP.
+func makeGELFSink(raw, content string) (*logSink, error) {
+	ls, err := makeLogSink("gelf", raw, content, "12201")
+	if err != nil {
+		return nil, err
+	}
+	ls.format = formatGELF
+	ls.frame = func(message []byte) [][]byte {
+		if ls.network == "tcp" {
+			return [][]byte{append(message, 0)}
+		}
+		return gelfChunks(message)
+	}
+	return ls, nil
+}
+
+func formatGELF(record sinkRecord, fields [][2]string, full bool) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	message := map[string]interface{}{
+		"version":       "1.1",
+		"host":          hostname,
+		"short_message": summaryMessage(record, fields),
+		"timestamp":     float64(record.Date.UnixNano()/1e6) / 1e3,
+		"level":         6,
+	}
+	for _, field := range fields {
+		// `_id` is reserved by GELF.
+		if field[0] == "id" {
+			field[0] = "record_id"
+		}
+		message["_"+field[0]] = field[1]
+	}
+	if full {
+		var content bytes.Buffer
+		if err := json.Compact(&content, record.Content); err != nil {
+			return nil, err
+		}
+		message["full_message"] = content.String()
+	}
+	return json.Marshal(message)
+}
+
+// gelfChunks splits a message larger than a datagram into GELF chunks,
+// returning nil if it has too many.
+func gelfChunks(message []byte) [][]byte {
+	if len(message) <= gelfChunkSize {
+		return [][]byte{message}
+	}
+	size := gelfChunkSize - 12
+	count := (len(message) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunks := [][]byte{}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(message) {
+			end = len(message)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, message[i*size:end]...))
+	}
+	return chunks
+}
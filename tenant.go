@@ -0,0 +1,194 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTenant is the tenant whose policy applies to the tenants without one.
+const defaultTenant = "*"
+
+// strictHeaders are the headers whose values are kept by strict redaction.
+var strictHeaders = map[string]bool{
+	"Accept":           true,
+	"Content-Encoding": true,
+	"Content-Length":   true,
+	"Content-Type":     true,
+}
+
+var tenantField = regexp.MustCompile(`"Tenant":\s*"((?:[^"\\]|\\.)*)"`)
+
+// tenantPolicy is the recording policy of a tenant.
+type tenantPolicy struct {
+	sampleRate float64
+	retention  time.Duration
+	strict     bool
+}
+
+// tenantPolicies applies recording policies to requests according to their
+// tenant, identified by a JWT claim or a header.
+type tenantPolicies struct {
+	claim, header string
+	policies      map[string]tenantPolicy
+	files         map[string]string
+}
+
+// makeTenantPolicies parses a `claim:<name>` or `header:<name>` key and
+// `<tenant>=sample:<rate>,retention:<duration>,redact:strict` policies.
+func makeTenantPolicies(key string, specs []string) (*tenantPolicies, error) {
+	if key == "" {
+		if len(specs) > 0 {
+			return nil, fmt.Errorf("--tenant-policy requires --tenant-key.")
+		}
+		return nil, nil
+	}
+	tp := &tenantPolicies{policies: map[string]tenantPolicy{}, files: map[string]string{}}
+	switch split := strings.SplitN(key, ":", 2); {
+	case len(split) == 2 && split[0] == "claim" && split[1] != "":
+		tp.claim = split[1]
+	case len(split) == 2 && split[0] == "header" && split[1] != "":
+		tp.header = split[1]
+	default:
+		return nil, fmt.Errorf("Invalid --tenant-key `%s`, expected `claim:<name>` or `header:<name>`.", key)
+	}
+
+	for _, spec := range specs {
+		split := strings.SplitN(spec, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("Invalid --tenant-policy `%s`, expected `<tenant>=<rule>[,<rule>...]`.", spec)
+		}
+		policy := tenantPolicy{sampleRate: 1}
+		for _, rule := range strings.Split(split[1], ",") {
+			option := strings.SplitN(strings.TrimSpace(rule), ":", 2)
+			if len(option) != 2 {
+				return nil, fmt.Errorf("Invalid --tenant-policy rule `%s`, expected `key:value`.", rule)
+			}
+			var err error
+			switch option[0] {
+			case "sample":
+				policy.sampleRate, err = strconv.ParseFloat(option[1], 64)
+				if err == nil && (policy.sampleRate < 0 || policy.sampleRate > 1) {
+					err = fmt.Errorf("expected a rate between 0 and 1")
+				}
+			case "retention":
+				policy.retention, err = time.ParseDuration(option[1])
+			case "redact":
+				if option[1] != "strict" && option[1] != "default" {
+					err = fmt.Errorf("expected `default` or `strict`")
+				}
+				policy.strict = option[1] == "strict"
+			default:
+				err = fmt.Errorf("unknown rule")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid --tenant-policy rule `%s`: %s", rule, err)
+			}
+		}
+		tp.policies[split[0]] = policy
+	}
+	return tp, nil
+}
+
+// tenantOf returns the tenant of a request, empty when unknown.
+func (tp *tenantPolicies) tenantOf(r *http.Request) string {
+	if tp == nil {
+		return ""
+	}
+	if tp.header != "" {
+		return r.Header.Get(tp.header)
+	}
+	auth := (&jwtDecoder{}).decode(r)
+	if auth == nil || auth.Claims[tp.claim] == nil {
+		return ""
+	}
+	return fmt.Sprint(auth.Claims[tp.claim])
+}
+
+// policy returns the policy of a tenant, falling back to the default one.
+func (tp *tenantPolicies) policy(tenant string) tenantPolicy {
+	if tp != nil {
+		if policy, ok := tp.policies[tenant]; ok {
+			return policy
+		}
+		if policy, ok := tp.policies[defaultTenant]; ok {
+			return policy
+		}
+	}
+	return tenantPolicy{sampleRate: 1}
+}
+
+// hasRetention tells whether a policy sets a retention.
+func (tp *tenantPolicies) hasRetention() bool {
+	if tp == nil {
+		return false
+	}
+	for _, policy := range tp.policies {
+		if policy.retention > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isSampledOut tells whether a request is dropped by the sample rate of its
+// tenant.
+func (ghr goHRec) isSampledOut(r *http.Request, req string) bool {
+	tenant := ghr.tenants.tenantOf(r)
+	if rate := ghr.tenants.policy(tenant).sampleRate; rate < 1 && rand.Float64() >= rate {
+		ghr.log(slog.LevelDebug, "Skipped: sampled out.", "request", req, "tenant", tenant)
+		ghr.recordSkip(r, "sampled-out")
+		return true
+	}
+	return false
+}
+
+// redactStrictly omits the body of a record and redacts its header values,
+// but the ones of strictHeaders, when its tenant policy is strict.
+func (ghr goHRec) redactStrictly(record *baseInfo) {
+	if !ghr.tenants.policy(record.Tenant).strict {
+		return
+	}
+	record.Body, record.BodyEncoding, record.BodyOmitted = "", "", true
+	for _, headers := range [][]string{record.Headers, record.Trailers} {
+		for i, header := range headers {
+			if split := strings.SplitN(header, ": ", 2); len(split) == 2 && !strictHeaders[http.CanonicalHeaderKey(split[0])] {
+				headers[i] = split[0] + ": " + redactedString
+			}
+		}
+	}
+}
+
+// retentionOf returns the retention of a record file, according to the
+// policy of the tenant found at its beginning.
+func (ghr goHRec) retentionOf(file string) time.Duration {
+	if !ghr.tenants.hasRetention() {
+		return ghr.retention
+	}
+	tenant, ok := ghr.tenants.files[file]
+	if !ok {
+		if reader, err := openRecordFile(file); err == nil {
+			head := make([]byte, 4096)
+			n, _ := io.ReadFull(reader, head)
+			reader.Close()
+			if match := tenantField.FindSubmatch(head[:n]); match != nil {
+				tenant, _ = strconv.Unquote(`"` + string(match[1]) + `"`)
+			}
+		}
+		ghr.tenants.files[file] = tenant
+	}
+	if retention := ghr.tenants.policy(tenant).retention; retention > 0 {
+		return retention
+	}
+	return ghr.retention
+}
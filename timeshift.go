@@ -0,0 +1,129 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultTimeShiftPatterns = []string{
+	`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`,
+	`(Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d{2} (Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) \d{4} \d{2}:\d{2}:\d{2} GMT`,
+}
+
+var timeShiftLayouts = []string{time.RFC3339Nano, http.TimeFormat}
+
+type timeShifter struct {
+	auto      bool
+	shift     time.Duration
+	patterns  []*regexp.Regexp
+	jsonPaths arrayJSONPathFlag
+}
+
+func makeTimeShifter(mode string, patterns []string, jsonPaths arrayJSONPathFlag) (timeShifter, error) {
+	ts := timeShifter{jsonPaths: jsonPaths}
+	switch mode {
+	case "":
+		return ts, nil
+	case "auto":
+		ts.auto = true
+	default:
+		shift, err := time.ParseDuration(mode)
+		if err != nil {
+			return ts, fmt.Errorf("Error while parsing time shift: %s", err)
+		}
+		ts.shift = shift
+	}
+
+	if len(patterns) == 0 {
+		patterns = defaultTimeShiftPatterns
+	}
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return ts, fmt.Errorf("Error while compiling time shift pattern: %s", err)
+		}
+		ts.patterns = append(ts.patterns, regex)
+	}
+	return ts, nil
+}
+
+func (ts timeShifter) enabled() bool {
+	return ts.auto || ts.shift != 0
+}
+
+func (ts timeShifter) shiftString(text string, delta time.Duration) string {
+	for _, pattern := range ts.patterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			for _, layout := range timeShiftLayouts {
+				if date, err := time.Parse(layout, match); err == nil {
+					if layout == http.TimeFormat {
+						return date.Add(delta).UTC().Format(layout)
+					}
+					return date.Add(delta).Format(layout)
+				}
+			}
+			return match
+		})
+	}
+	return text
+}
+
+func (ts timeShifter) shiftJSONValue(value interface{}, delta time.Duration) interface{} {
+	switch v := value.(type) {
+	case string:
+		return ts.shiftString(v, delta)
+	case json.Number:
+		unix, err := v.Int64()
+		if err != nil {
+			return value
+		}
+		switch len(strings.TrimPrefix(v.String(), "-")) {
+		case 13:
+			return json.Number(strconv.FormatInt(unix+delta.Milliseconds(), 10))
+		case 10:
+			return json.Number(strconv.FormatInt(unix+int64(delta/time.Second), 10))
+		}
+	}
+	return value
+}
+
+// apply rewrites the timestamps of the record so that they keep their original
+// offset to the record date, relative to now.
+func (ts timeShifter) apply(record *redoRecord) {
+	if !ts.enabled() {
+		return
+	}
+
+	delta := ts.shift
+	if ts.auto {
+		delta = time.Now().Sub(time.Unix(0, record.DateUnixNano))
+	}
+
+	headers := make([]string, len(record.Headers))
+	for i, header := range record.Headers {
+		headers[i] = ts.shiftString(header, delta)
+	}
+	record.Headers = headers
+
+	if len(ts.jsonPaths) > 0 {
+		if doc, ok := decodeJSON(record.Body); ok {
+			for _, path := range ts.jsonPaths {
+				doc = path.replace(doc, func(value interface{}) interface{} {
+					return ts.shiftJSONValue(value, delta)
+				})
+			}
+			record.Body = encodeJSON(doc)
+			return
+		}
+	}
+	record.Body = ts.shiftString(record.Body, delta)
+}
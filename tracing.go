@@ -0,0 +1,273 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tracerBatchSize     = 512
+	tracerFlushInterval = 5 * time.Second
+
+	spanKindServer = 2
+	spanKindClient = 3
+
+	spanStatusError = 2
+)
+
+var traceparentParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+type clientSpanKey struct{}
+
+// span is an OpenTelemetry span, serialized as OTLP JSON.
+type span struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []spanAttribute `json:"attributes"`
+	Status       struct {
+		Code int `json:"code,omitempty"`
+	} `json:"status"`
+	flags      string
+	mutex      sync.Mutex
+	ended      bool
+	statusCode int
+}
+
+type spanAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// startSpan starts a span of a trace, a new trace being started without
+// parent.
+func startSpan(name string, kind int, parent *span, start time.Time) *span {
+	s := &span{SpanID: randomHex(8), Name: name, Kind: kind, Start: unixNano(start), flags: "01"}
+	if parent != nil {
+		s.TraceID, s.ParentSpanID, s.flags = parent.TraceID, parent.SpanID, parent.flags
+	} else {
+		s.TraceID = randomHex(16)
+	}
+	return s
+}
+
+// startServerSpan starts the span of an incoming request, continuing the
+// trace of its W3C traceparent if any.
+func startServerSpan(r *http.Request, start time.Time) *span {
+	s := startSpan(r.Method, spanKindServer, nil, start)
+	if match := traceparentParentPattern.FindStringSubmatch(strings.TrimSpace(r.Header.Get("Traceparent"))); match != nil && match[1] != zeroTraceID {
+		s.TraceID, s.ParentSpanID, s.flags = match[1], match[2], match[3]
+	}
+	s.attribute("http.request.method", r.Method)
+	s.attribute("url.path", r.URL.Path)
+	s.attribute("server.address", r.Host)
+	s.attribute("client.address", r.RemoteAddr)
+	return s
+}
+
+// traceparent returns the W3C traceparent propagating the span.
+func (s *span) traceparent() string {
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + s.flags
+}
+
+func (s *span) attribute(key string, value interface{}) {
+	switch value := value.(type) {
+	case int:
+		s.Attributes = append(s.Attributes, spanAttribute{key, map[string]interface{}{"intValue": strconv.Itoa(value)}})
+	default:
+		s.Attributes = append(s.Attributes, spanAttribute{key, map[string]interface{}{"stringValue": fmt.Sprint(value)}})
+	}
+}
+
+// end ends the span with the status code of the response, 0 if it failed,
+// telling whether it was not ended yet.
+func (s *span) end(statusCode int, end time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ended {
+		return false
+	}
+	s.ended = true
+	s.statusCode = statusCode
+	s.End = unixNano(end)
+	if statusCode > 0 {
+		s.attribute("http.response.status_code", statusCode)
+	}
+	if statusCode == 0 || statusCode >= 500 || (s.Kind == spanKindClient && statusCode >= 400) {
+		s.Status.Code = spanStatusError
+	}
+	return true
+}
+
+// traceProxy starts the server span of a proxied request and the client span
+// of its forwarding to upstream, propagated with its traceparent, returning
+// the request to forward and the function ending and exporting both spans.
+func (ghr goHRec) traceProxy(r *http.Request, upstream *url.URL, received time.Time, id string) (*http.Request, func()) {
+	if ghr.tracer == nil {
+		return r, func() {}
+	}
+	server := startServerSpan(r, received)
+	if id != "" {
+		server.attribute("gohrec.record_id", id)
+	}
+	client := startSpan(r.Method, spanKindClient, server, time.Now())
+	client.attribute("http.request.method", r.Method)
+	client.attribute("server.address", upstream.Host)
+	client.attribute("url.full", upstream.Scheme+"://"+upstream.Host+r.URL.RequestURI())
+	r.Header.Set("Traceparent", client.traceparent())
+	r = r.WithContext(context.WithValue(r.Context(), clientSpanKey{}, client))
+
+	return r, func() {
+		now := time.Now()
+		client.end(0, now)
+		statusCode := client.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusBadGateway
+		}
+		server.end(statusCode, now)
+		ghr.tracer.export(server)
+		ghr.tracer.export(client)
+	}
+}
+
+func clientSpanOf(r *http.Request) *span {
+	if r == nil {
+		return nil
+	}
+	s, _ := r.Context().Value(clientSpanKey{}).(*span)
+	return s
+}
+
+// tracer exports spans to an OTLP/HTTP endpoint as JSON, flushing batches
+// when they are full or every five seconds.
+type tracer struct {
+	url      string
+	service  string
+	client   http.Client
+	logError errorLogger
+	mutex    sync.Mutex
+	batch    []*span
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func makeTracer(endpoint, service, via string, logError errorLogger) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	t := &tracer{
+		url:      url,
+		service:  service,
+		client:   http.Client{Timeout: 30 * time.Second, Transport: makeViaTransport(via, nil)},
+		logError: logError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *tracer) export(s *span) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	t.batch = append(t.batch, s)
+	full := len(t.batch) >= tracerBatchSize
+	t.mutex.Unlock()
+	if full {
+		go t.flush()
+	}
+}
+
+func (t *tracer) flush() {
+	t.mutex.Lock()
+	spans := t.batch
+	t.batch = nil
+	t.mutex.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	service := spanAttribute{"service.name", map[string]interface{}{"stringValue": t.service}}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []spanAttribute{service}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "gohrec"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		t.logError(errorUpstream, "Error while serializing spans", err, "spans", len(spans))
+		return
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.logError(errorUpstream, "Error while exporting spans", err, "spans", len(spans))
+		return
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		t.logError(errorUpstream, "Error while exporting spans", fmt.Errorf("unexpected status %s", resp.Status), "spans", len(spans))
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracerFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush()
+		case <-t.stop:
+			t.flush()
+			return
+		}
+	}
+}
+
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
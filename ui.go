@@ -0,0 +1,278 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// uiRedoMaxBody is the maximum size of the response bodies shown by the UI.
+const uiRedoMaxBody = 1 << 20
+
+// uiRedoResult is the response to a request redone from the UI.
+type uiRedoResult struct {
+	URL        string
+	Status     string `json:",omitempty"`
+	StatusCode int    `json:",omitempty"`
+	Headers    []string
+	Body       string
+	Duration   string
+	Error      string `json:",omitempty"`
+}
+
+// uiHandler serves the single page UI browsing the records.
+func (ghr goHRec) uiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write([]byte(uiPage))
+}
+
+// uiRedoHandler redoes with POST the request of a record, to the `target`
+// URL if set, or else to the route matching it or the --target-url, the
+// target being one of them so that records are not sent to any host.
+func (ghr goHRec) uiRedoHandler(w http.ResponseWriter, r *http.Request) {
+	if !ghr.authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		http.Error(w, "Cross-site requests are not allowed.", http.StatusForbidden)
+		return
+	}
+	id := r.PathValue("id")
+
+	record, err := ghr.indexedRecord(id)
+	if err == errRecordNotFound {
+		http.Error(w, "Record not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ghr.logError(errorStorage, "Error while reading index", err, "id", id)
+		http.Error(w, "Error while reading index.", http.StatusInternalServerError)
+		return
+	}
+	var request redoRecord
+	err = errRecordNotFound
+	for _, file := range record.Files {
+		if isRecordFile(file, "request") || isRecordFile(file, "pair") {
+			request, err = loadRedoRecord(file)
+			break
+		}
+	}
+	if err == errRecordNotFound {
+		http.Error(w, "Request record not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ghr.logError(errorStorage, "Error while reading record", err, "id", id)
+		http.Error(w, "Error while reading record.", http.StatusInternalServerError)
+		return
+	}
+
+	target, err := ghr.uiRedoTarget(request, r.URL.Query().Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	rd := redoer{client: http.Client{Timeout: 30 * time.Second}}
+	req, err := rd.prepare(request, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := uiRedoResult{URL: req.URL.String()}
+	start := time.Now()
+	resp, err := rd.client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, uiRedoMaxBody))
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Status, result.StatusCode = resp.Status, resp.StatusCode
+		result.Headers = dumpValues(resp.Header)
+		result.Body = string(body)
+	}
+	result.Duration = time.Since(start).String()
+	ghr.log(slog.LevelInfo, "Redone", "id", id, "url", result.URL, "status", result.StatusCode)
+	writeAdminJSON(w, result)
+}
+
+// uiRedoTarget returns the target of a redo, the routes and --target-url
+// being the only ones allowed.
+func (ghr goHRec) uiRedoTarget(record redoRecord, target string) (string, error) {
+	allowed := []*url.URL{}
+	for _, route := range ghr.routes {
+		allowed = append(allowed, route.target)
+	}
+	if ghr.targetURL != nil {
+		allowed = append(allowed, ghr.targetURL)
+	}
+	if target == "" {
+		u, err := url.Parse(record.URI)
+		if err != nil {
+			return "", fmt.Errorf("Invalid recorded URI `%s`.", record.URI)
+		}
+		if route := ghr.routes.Match(&http.Request{Host: record.Host, URL: u}); route != nil {
+			return route.String(), nil
+		}
+		if ghr.targetURL != nil {
+			return ghr.targetURL.String(), nil
+		}
+		return "", fmt.Errorf("No target: set one of the --target-url or --route targets.")
+	}
+	for _, u := range allowed {
+		if strings.TrimSuffix(u.String(), "/") == strings.TrimSuffix(target, "/") {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("Target `%s` not allowed, expected the --target-url or a --route target.", target)
+}
+
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gohrec</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+#list { width: 40%; overflow-y: auto; border-right: 1px solid #ccc; }
+#details { flex: 1; overflow-y: auto; padding: 0 1em; }
+form { padding: .5em; background: #f4f4f4; position: sticky; top: 0; }
+table { border-collapse: collapse; width: 100%; }
+td { border-bottom: 1px solid #eee; padding: .2em .5em; font-size: .9em; cursor: pointer; white-space: nowrap; }
+tr:hover, tr.selected { background: #e8f0fe; }
+pre { background: #f4f4f4; padding: .5em; overflow-x: auto; white-space: pre-wrap; word-break: break-all; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<div id="list">
+<form id="filter">
+<input id="path" placeholder="Path prefix (like /api)">
+<input id="since" placeholder="Since (like 1h)" size="10">
+<button>Search</button>
+</form>
+<table><tbody id="records"></tbody></table>
+</div>
+<div id="details"><p>Select a record.</p></div>
+<script>
+const $ = (id) => document.getElementById(id);
+
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (className) e.className = className;
+  return e;
+}
+
+async function api(url, options) {
+  options = options || {};
+  const token = sessionStorage.getItem("gohrec-token");
+  options.headers = token ? {Authorization: "Bearer " + token} : {};
+  let resp = await fetch(url, options);
+  if (resp.status === 401) {
+    const token = prompt("Admin token:");
+    if (token === null) throw new Error("Unauthorized.");
+    sessionStorage.setItem("gohrec-token", token);
+    return api(url, options);
+  }
+  if (!resp.ok) throw new Error(await resp.text());
+  return resp.json();
+}
+
+function body(record) {
+  if (record.BodyOmitted) return "(body omitted)";
+  if (record.BodyEncoding === "base64") return "(binary body, " + atob(record.Body).length + " bytes)";
+  try {
+    return JSON.stringify(JSON.parse(record.Body), null, 2);
+  } catch (e) {
+    return record.Body;
+  }
+}
+
+function section(title, record, lines) {
+  const div = el("div");
+  div.appendChild(el("h3", title));
+  div.appendChild(el("pre", lines.concat(record.Headers || []).join("\n")));
+  if (record.Body || record.BodyOmitted) div.appendChild(el("pre", body(record)));
+  return div;
+}
+
+async function show(id, row) {
+  document.querySelectorAll("tr.selected").forEach((tr) => tr.classList.remove("selected"));
+  row.classList.add("selected");
+  const details = $("details");
+  details.replaceChildren(el("h2", id));
+  try {
+    const kinds = await api("/gohrec/records/" + encodeURIComponent(id));
+    const request = kinds.request || (kinds.pair && kinds.pair.Request);
+    const response = kinds.response || (kinds.pair && kinds.pair.Response);
+    if (request) details.appendChild(section("Request", request, [request.Method + " " + request.URI + " " + request.Protocol, "Host: " + request.Host]));
+    if (response) details.appendChild(section("Response", response, [response.Protocol + " " + response.Status]));
+    if (kinds.skip) details.appendChild(section("Skipped", kinds.skip, []));
+    if (kinds.annotations) details.appendChild(el("pre", JSON.stringify(kinds.annotations, null, 2)));
+    if (request) {
+      const form = el("form");
+      const target = el("input");
+      target.placeholder = "Target URL (--target-url or a --route target)";
+      target.size = 40;
+      form.appendChild(target);
+      form.appendChild(el("button", "Redo"));
+      const result = el("div");
+      form.onsubmit = async (event) => {
+        event.preventDefault();
+        result.replaceChildren(el("p", "Redoing..."));
+        try {
+          const redone = await api("/gohrec/records/" + encodeURIComponent(id) + "/redo?target=" + encodeURIComponent(target.value), {method: "POST"});
+          result.replaceChildren(section("Redone " + redone.URL + " in " + redone.Duration, redone, redone.Error ? [redone.Error] : ["HTTP " + redone.Status]));
+        } catch (e) {
+          result.replaceChildren(el("p", e.message, "error"));
+        }
+      };
+      details.appendChild(form);
+      details.appendChild(result);
+    }
+  } catch (e) {
+    details.appendChild(el("p", e.message, "error"));
+  }
+}
+
+async function list(event) {
+  if (event) event.preventDefault();
+  const tbody = $("records");
+  tbody.replaceChildren();
+  try {
+    const params = new URLSearchParams({order: "desc", limit: "500", path: $("path").value, since: $("since").value});
+    for (const record of await api("/gohrec/records?" + params)) {
+      const row = el("tr");
+      row.appendChild(el("td", new Date(record.Date).toLocaleString()));
+      row.appendChild(el("td", record.Request.split(" ").slice(1).join(" ")));
+      row.onclick = () => show(record.ID, row);
+      tbody.appendChild(row);
+    }
+  } catch (e) {
+    tbody.appendChild(el("tr")).appendChild(el("td", e.message, "error"));
+  }
+}
+
+$("filter").onsubmit = list;
+list();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	variableNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	placeholderRegex  = regexp.MustCompile(`{{\s*([A-Za-z0-9_.-]+)\s*}}`)
+)
+
+// variables expands `{{name}}` placeholders in the URI, host, headers and
+// body of records, placeholders of unknown variables being left untouched.
+type variables map[string]string
+
+func makeVariables(specs []string) (variables, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	vars := variables{}
+	for _, spec := range specs {
+		split := strings.SplitN(spec, "=", 2)
+		if len(split) != 2 || !variableNameRegex.MatchString(split[0]) {
+			return nil, fmt.Errorf("Invalid --var `%s`, expected `<name>=<value>`.", spec)
+		}
+		vars[split[0]] = split[1]
+	}
+	return vars, nil
+}
+
+func (vars variables) expand(value string) string {
+	return placeholderRegex.ReplaceAllStringFunc(value, func(placeholder string) string {
+		if value, ok := vars[placeholderRegex.FindStringSubmatch(placeholder)[1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+func (vars variables) apply(record *redoRecord) {
+	if vars == nil {
+		return
+	}
+	record.URI = vars.expand(record.URI)
+	record.Host = vars.expand(record.Host)
+	record.Body = vars.expand(record.Body)
+	headers := make([]string, len(record.Headers))
+	for i, header := range record.Headers {
+		headers[i] = vars.expand(header)
+	}
+	record.Headers = headers
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// verificationIgnored replaces the values ignored by --verify-ignore and
+// --verify-ignore-pattern.
+const verificationIgnored = "<ignored>"
+
+type verificationEntry struct {
+	Request     string
+	Method, URI string
+	Passed      bool
+	Failures    []string `json:",omitempty"`
+	Expected    int      `json:",omitempty"`
+	StatusCode  int      `json:",omitempty"`
+	Redirects   []string `json:",omitempty"`
+	Error       string   `json:",omitempty"`
+}
+
+// verificationReport is written by --verify-report.
+type verificationReport struct {
+	Requests, Passed, Failed, Unverified int
+	Entries                              []verificationEntry
+}
+
+// verification compares the live responses of redone requests to their
+// recorded responses: status, headers of headerNames, and body, ignoring the
+// values at ignorePaths in JSON bodies and the matches of ignorePatterns.
+type verification struct {
+	headerNames    []string
+	ignorePaths    []jsonPath
+	ignorePatterns []*regexp.Regexp
+	mutex          sync.Mutex
+	entries        []verificationEntry
+	failed         int
+	unverified     int
+}
+
+func makeVerification(headerNames []string, ignorePaths []jsonPath, ignorePatterns []string) (*verification, error) {
+	v := &verification{ignorePaths: ignorePaths}
+	for _, names := range headerNames {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				v.headerNames = append(v.headerNames, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	for _, pattern := range ignorePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --verify-ignore-pattern `%s`: %s", pattern, err)
+		}
+		v.ignorePatterns = append(v.ignorePatterns, re)
+	}
+	return v, nil
+}
+
+// loadRecordedResponse loads the response recorded along with a request,
+// from its pair record or from the response record next to it.
+func loadRecordedResponse(requestFile string) (responseRecord, error) {
+	var record responseRecord
+	var content []byte
+	var err error
+	if isRecordFile(requestFile, "pair") {
+		if content, err = readRecordFile(requestFile); err == nil {
+			content, err = recordOfPair(content, "response")
+		}
+	} else {
+		content, err = readRecordFile(strings.Replace(requestFile, ".request.json", ".response.json", 1))
+	}
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(content, &record); err != nil {
+		return record, err
+	}
+	record.Body, err = decodeBody(record.Body, record.BodyEncoding)
+	return record, err
+}
+
+// normalizeBody applies the ignore rules to a body, JSON ones being
+// re-encoded with sorted keys.
+func (v *verification) normalizeBody(body string) string {
+	if doc, ok := decodeJSON(body); ok {
+		for _, path := range v.ignorePaths {
+			doc = path.replace(doc, func(interface{}) interface{} { return verificationIgnored })
+		}
+		body = encodeJSON(doc)
+	}
+	for _, re := range v.ignorePatterns {
+		body = re.ReplaceAllString(body, verificationIgnored)
+	}
+	return body
+}
+
+// compare returns the differences of a live response to a recorded one.
+func (v *verification) compare(recorded responseRecord, statusCode int, header http.Header, body string) []string {
+	failures := []string{}
+	if statusCode != recorded.StatusCode {
+		failures = append(failures, fmt.Sprintf("status: expected %d, got %d", recorded.StatusCode, statusCode))
+	}
+	for _, name := range v.headerNames {
+		expected, got := findHeader(recorded.Headers, name), header.Get(name)
+		for _, re := range v.ignorePatterns {
+			expected, got = re.ReplaceAllString(expected, verificationIgnored), re.ReplaceAllString(got, verificationIgnored)
+		}
+		if expected != got {
+			failures = append(failures, fmt.Sprintf("header %s: expected `%s`, got `%s`", name, expected, got))
+		}
+	}
+	if !recorded.BodyOmitted && recorded.BodyTruncated == nil && v.normalizeBody(recorded.Body) != v.normalizeBody(body) {
+		failures = append(failures, "body: differs")
+	}
+	return failures
+}
+
+// verify redoes a request and compares its response to the recorded one.
+func (rd redoer) verify(file redoFile) {
+	entry := verificationEntry{Request: file.name, Method: file.record.Method, URI: file.record.URI}
+	recorded, err := loadRecordedResponse(file.name)
+	if err != nil {
+		entry.Error = fmt.Sprintf("No recorded response: %s", err)
+		log.Printf("Unverified: %s %s (%s)", entry.Method, entry.URI, entry.Error)
+		rd.verification.add(entry, true)
+		return
+	}
+	entry.Expected = recorded.StatusCode
+
+	req, err := rd.prepare(file.record, "")
+	if err == nil {
+		var resp *http.Response
+		if resp, err = rd.client.Do(req); err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			entry.StatusCode = resp.StatusCode
+			entry.Redirects = redirectChain(resp)
+			if err == nil && rd.saver != nil {
+				rd.saveResponse(file.record, req, resp, body)
+			}
+			if err == nil {
+				entry.Failures = rd.verification.compare(recorded, resp.StatusCode, resp.Header, string(body))
+			}
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Failures = append(entry.Failures, "error: "+entry.Error)
+	}
+	entry.Passed = len(entry.Failures) == 0
+
+	if entry.Passed {
+		log.Printf("Passed: %s %s [%d]", entry.Method, entry.URI, entry.StatusCode)
+	} else {
+		log.Printf("Failed: %s %s [%d] (%s)", entry.Method, entry.URI, entry.StatusCode, strings.Join(entry.Failures, "; "))
+	}
+	rd.verification.add(entry, false)
+}
+
+func (v *verification) add(entry verificationEntry, unverified bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.entries = append(v.entries, entry)
+	if unverified {
+		v.unverified++
+	} else if !entry.Passed {
+		v.failed++
+	}
+}
+
+// report logs the verification summary and writes its report to file if
+// set, returning the number of failures.
+func (v *verification) report(file string) int {
+	passed := len(v.entries) - v.failed - v.unverified
+	log.Printf("Verified %d request(s): %d passed, %d failed, %d without recorded response", len(v.entries), passed, v.failed, v.unverified)
+
+	if file != "" {
+		report := verificationReport{len(v.entries), passed, v.failed, v.unverified, v.entries}
+		content, err := json.MarshalIndent(report, "", " ")
+		if err != nil {
+			log.Printf("Error while serializing verification report: %s", err)
+		} else if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			log.Printf("Error while writing verification report: %s", err)
+		} else {
+			log.Printf("Verification report written to %s", file)
+		}
+	}
+	return v.failed
+}
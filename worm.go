@@ -0,0 +1,69 @@
+// Copyright (c) 2020 FEROX YT EIRL, www.ferox.yt <devops@ferox.yt>
+// Copyright (c) 2020 Jérémy WALTHER <jeremy.walther@golflima.net>
+// See <https://github.com/frxyt/gohrec> for details.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// writeOnce creates a file that must not exist yet, and leaves it read-only.
+func writeOnce(filename string, content []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFile writes a file produced by gohrec, without ever overwriting one
+// when --worm is set.
+func (ghr goHRec) writeFile(filename string, content []byte) error {
+	if ghr.worm {
+		return writeOnce(filename, content, ghr.fileMode())
+	}
+	return ioutil.WriteFile(filename, content, ghr.fileMode())
+}
+
+// fileMode restricts quarantined records to their owner and WORM records to
+// reading.
+func (ghr goHRec) fileMode() os.FileMode {
+	switch {
+	case ghr.quarantine && ghr.worm:
+		return 0400
+	case ghr.quarantine:
+		return 0600
+	case ghr.worm:
+		return 0440
+	}
+	return 0644
+}
+
+func (ghr goHRec) dirMode() os.FileMode {
+	switch {
+	case ghr.quarantine:
+		return 0700
+	case ghr.worm:
+		return 0750
+	}
+	return 0755
+}
+
+// indexFlags opens the index append-only when --worm is set, it is otherwise
+// also read to be trimmed by the janitor.
+func (ghr goHRec) indexFlags() (int, os.FileMode) {
+	if ghr.worm {
+		return os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0640
+	}
+	return os.O_APPEND | os.O_CREATE | os.O_RDWR, 0644
+}